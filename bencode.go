@@ -6,6 +6,13 @@
 // type assertion over reflection for performance.
 package bencode
 
+import (
+	"errors"
+	"io"
+	"iter"
+	"sort"
+)
+
 // Dict represents a bencode dictionary.
 type Dict map[string]interface{}
 
@@ -14,6 +21,63 @@ func NewDict() Dict {
 	return make(Dict)
 }
 
+// WriteTo writes the bencoding of d to w, implementing io.WriterTo. It
+// returns the number of bytes written, including those written before an
+// error, for use in metrics.
+func (d Dict) WriteTo(w io.Writer) (int64, error) {
+	e := encodeState{w: w}
+	err := e.marshal(d)
+	if e.err != nil {
+		err = e.err
+	}
+	return e.written, err
+}
+
+// All returns an iterator over the entries of d, in the sorted key order
+// that bencoding requires.
+func (d Dict) All() iter.Seq2[string, interface{}] {
+	return func(yield func(string, interface{}) bool) {
+		keys := make([]string, 0, len(d))
+		for k := range d {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if !yield(k, d[k]) {
+				return
+			}
+		}
+	}
+}
+
+// An OrderedDict is a bencode dictionary that keeps the order of its
+// entries. Unlike a Dict, it is encoded with its entries in the order given
+// rather than sorted by key, so that a dictionary such as one from a torrent
+// written by a non-conforming client can be reproduced byte for byte.
+//
+// When a value is decoded into an OrderedDict, the dictionaries nested in
+// it are decoded as OrderedDicts too, and entries are kept in the order they
+// appear in the input, duplicates included.
+type OrderedDict []DictEntry
+
+// A DictEntry is an entry of an OrderedDict.
+type DictEntry struct {
+	Key   string
+	Value interface{}
+}
+
+// Get returns the value of the first entry of d with the given key, and
+// whether there is one.
+func (d OrderedDict) Get(key string) (interface{}, bool) {
+	for _, e := range d {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
 // List represents a bencode list.
 type List []interface{}
 
@@ -21,3 +85,61 @@ type List []interface{}
 func NewList() List {
 	return make(List, 0)
 }
+
+// RawBytes is a raw bencoded value. It implements Marshaler and Unmarshaler
+// and can be used to delay decoding part of a message, or to keep the exact
+// encoding of a value such as the info dictionary of a torrent, whose hash
+// must be computed over the bytes as they were received.
+//
+// Unlike a []byte, which is encoded as a byte string, a RawBytes is written
+// verbatim, so it must hold exactly one valid bencoded value.
+type RawBytes []byte
+
+var errEmptyRawBytes = errors.New("bencode: empty RawBytes")
+
+// MarshalBencode returns m as the bencoding of m.
+func (m RawBytes) MarshalBencode() ([]byte, error) {
+	if len(m) == 0 {
+		return nil, errEmptyRawBytes
+	}
+	return m, nil
+}
+
+// UnmarshalBencode sets *m to a copy of data.
+func (m *RawBytes) UnmarshalBencode(data []byte) error {
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+// A Tee holds a decoded value together with the exact bytes it was decoded
+// from, so that the infohash of a torrent can be computed over its info
+// dictionary as received, rather than over a re-encoding that may differ
+// from it:
+//
+//	var torrent struct {
+//		Info bencode.Tee[Info] `bencode:"info"`
+//	}
+//	...
+//	infohash := sha1.Sum(torrent.Info.Raw)
+//
+// A Tee is encoded as Raw if it is set, and as Value otherwise. Value is
+// decoded as by Unmarshal, without the configuration of the Decoder.
+type Tee[T any] struct {
+	Value T
+	Raw   RawBytes
+}
+
+// MarshalBencode returns t.Raw, or the bencoding of t.Value if it is empty.
+func (t Tee[T]) MarshalBencode() ([]byte, error) {
+	if len(t.Raw) > 0 {
+		return t.Raw, nil
+	}
+	return Marshal(t.Value)
+}
+
+// UnmarshalBencode sets t.Raw to a copy of data, and decodes data into
+// t.Value.
+func (t *Tee[T]) UnmarshalBencode(data []byte) error {
+	t.Raw = append(t.Raw[:0], data...)
+	return Unmarshal(t.Raw, &t.Value)
+}
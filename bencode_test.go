@@ -0,0 +1,163 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDictAll(t *testing.T) {
+	d := Dict{"b": int64(2), "a": int64(1), "c": int64(3)}
+
+	var keys []string
+	var sum int64
+	for k, v := range d.All() {
+		keys = append(keys, k)
+		sum += v.(int64)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", keys, expected)
+	}
+	if sum != 6 {
+		t.Errorf("got sum %d, expected 6", sum)
+	}
+
+	for k := range d.All() {
+		if k != "a" {
+			t.Errorf("expected iteration to stop after first key, got %q", k)
+		}
+		break
+	}
+}
+
+func TestDictWriteTo(t *testing.T) {
+	d := Dict{"interval": int64(1800), "peers": List{Dict{"ip": "10.0.0.1"}}}
+	expected := "d8:intervali1800e5:peersld2:ip8:10.0.0.1eee"
+
+	var buf bytes.Buffer
+	n, err := d.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected || n != int64(len(expected)) {
+		t.Errorf("\ngot:      %s (%d bytes)\nexpected: %s (%d bytes)", buf.String(), n, expected, len(expected))
+	}
+
+	n, err = d.WriteTo(&limitWriter{n: 20})
+	if err != errWriteLimit || n != 20 {
+		t.Errorf("\ngot:      %d, %v\nexpected: 20, %v", n, err, errWriteLimit)
+	}
+	n, err = Dict{"a": 1.5}.WriteTo(&buf)
+	if err == nil || n != 4 {
+		t.Errorf("got %d, %v; expected 4 bytes and an error", n, err)
+	}
+}
+
+func TestOrderedDictRoundTrip(t *testing.T) {
+	data := "d4:zeta1:a5:alphald1:yi1e1:xi2eee4:zeta1:be"
+	var od OrderedDict
+	if err := Unmarshal([]byte(data), &od); err != nil {
+		t.Fatal(err)
+	}
+	expected := OrderedDict{
+		{"zeta", "a"},
+		{"alpha", List{OrderedDict{{"y", int64(1)}, {"x", int64(2)}}}},
+		{"zeta", "b"},
+	}
+	if !reflect.DeepEqual(od, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", od, expected)
+	}
+	if v, ok := od.Get("zeta"); !ok || v != "a" {
+		t.Errorf("\ngot:      %#v, %t\nexpected: %#v, true", v, ok, "a")
+	}
+	if v, ok := od.Get("beta"); ok {
+		t.Errorf("unexpected value %#v", v)
+	}
+
+	got, err := Marshal(od)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, data)
+	}
+}
+
+func TestOrderedDictField(t *testing.T) {
+	data := "d8:announce1:u4:infod6:pieces0:4:name1:aee"
+	var v struct {
+		Announce string      `bencode:"announce"`
+		Info     OrderedDict `bencode:"info"`
+	}
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, data)
+	}
+}
+
+func TestOrderedDictNil(t *testing.T) {
+	od := OrderedDict{{"b", nil}, {"a", "x"}}
+	got, err := Marshal(od)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d1:a1:xe"; string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	err = NewEncoder(io.Discard, WithNilPolicy(NilError)).Encode(od)
+	expected := &NilValueError{Type: reflect.TypeOf(od), Key: "b"}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", err, expected)
+	}
+}
+
+func TestTee(t *testing.T) {
+	// The info dictionary's keys are out of order, so re-encoding it would
+	// change its hash.
+	data := "d8:announce1:u4:infod4:name1:a6:lengthi5ee1:xi1ee"
+	var torrent struct {
+		Announce string `bencode:"announce"`
+		Info     Tee[struct {
+			Name   string `bencode:"name"`
+			Length int64  `bencode:"length"`
+		}] `bencode:"info"`
+	}
+	if err := Unmarshal([]byte(data), &torrent); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d4:name1:a6:lengthi5ee"; string(torrent.Info.Raw) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", torrent.Info.Raw, expected)
+	}
+	if v := torrent.Info.Value; v.Name != "a" || v.Length != 5 {
+		t.Errorf("got %#v", v)
+	}
+
+	got, err := Marshal(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d8:announce1:u4:infod4:name1:a6:lengthi5eee"; string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	torrent.Info.Raw = nil
+	got, err = Marshal(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d8:announce1:u4:infod6:lengthi5e4:name1:aee"; string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+}
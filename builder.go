@@ -0,0 +1,295 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// A DictBuilder builds the bencoding of a dictionary one entry at a time,
+// as in:
+//
+//	b, err := bencode.NewDictBuilder().
+//		Str("failure reason", msg).
+//		Int("interval", 1800).
+//		Build()
+//
+// Each value is encoded as it is added, so that a value that cannot be
+// encoded is reported by Build, along with its key. Entries may be added in
+// any order; they are written in sorted key order.
+type DictBuilder struct {
+	entries []builderEntry
+	err     error // first error adding an entry
+}
+
+// A builderEntry is a dictionary entry with its value already encoded.
+type builderEntry struct {
+	key   string
+	value []byte
+}
+
+// NewDictBuilder returns an empty DictBuilder.
+func NewDictBuilder() *DictBuilder {
+	return &DictBuilder{}
+}
+
+func (b *DictBuilder) add(key string, value []byte) *DictBuilder {
+	b.entries = append(b.entries, builderEntry{key, value})
+	return b
+}
+
+func (b *DictBuilder) fail(key string, err error) *DictBuilder {
+	if b.err == nil {
+		b.err = fmt.Errorf("bencode: value for key %q: %w", key, err)
+	}
+	return b
+}
+
+// Str adds an entry with a byte string value.
+func (b *DictBuilder) Str(key, v string) *DictBuilder {
+	return b.add(key, appendString(nil, v))
+}
+
+// Bytes adds an entry with a byte string value.
+func (b *DictBuilder) Bytes(key string, v []byte) *DictBuilder {
+	return b.add(key, appendBytes(nil, v))
+}
+
+// Int adds an entry with an integer value.
+func (b *DictBuilder) Int(key string, v int64) *DictBuilder {
+	return b.add(key, appendInt(nil, v))
+}
+
+// Uint adds an entry with an integer value.
+func (b *DictBuilder) Uint(key string, v uint64) *DictBuilder {
+	return b.add(key, appendUint(nil, v))
+}
+
+// Bool adds an entry with the integer value 1 if v is true, and 0 otherwise.
+func (b *DictBuilder) Bool(key string, v bool) *DictBuilder {
+	if v {
+		return b.add(key, []byte("i1e"))
+	}
+	return b.add(key, []byte("i0e"))
+}
+
+// Dict adds an entry whose value is the dictionary built by d.
+func (b *DictBuilder) Dict(key string, d *DictBuilder) *DictBuilder {
+	v, err := d.Build()
+	if err != nil {
+		return b.fail(key, err)
+	}
+	return b.add(key, v)
+}
+
+// List adds an entry whose value is the list built by l.
+func (b *DictBuilder) List(key string, l *ListBuilder) *DictBuilder {
+	v, err := l.Build()
+	if err != nil {
+		return b.fail(key, err)
+	}
+	return b.add(key, v)
+}
+
+// Value adds an entry whose value is the bencoding of v, as returned by
+// Marshal.
+func (b *DictBuilder) Value(key string, v interface{}) *DictBuilder {
+	e, err := Marshal(v)
+	if err != nil {
+		return b.fail(key, err)
+	}
+	return b.add(key, e)
+}
+
+// Build returns the bencoding of the dictionary, or the first error adding
+// an entry to it. Adding two entries with the same key is an error.
+func (b *DictBuilder) Build() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	sort.SliceStable(b.entries, func(i, j int) bool {
+		return b.entries[i].key < b.entries[j].key
+	})
+
+	n := 2
+	for i, entry := range b.entries {
+		if i > 0 && entry.key == b.entries[i-1].key {
+			return nil, fmt.Errorf("bencode: duplicate key %q", entry.key)
+		}
+		n += len(entry.key) + len(entry.value) + 21 // with the key's length prefix
+	}
+	out := make([]byte, 0, n)
+	out = append(out, 'd')
+	for _, entry := range b.entries {
+		out = appendString(out, entry.key)
+		out = append(out, entry.value...)
+	}
+	return append(out, 'e'), nil
+}
+
+// WriteTo writes the bencoding of the dictionary to w, implementing
+// io.WriterTo. Nothing is written if Build would return an error.
+func (b *DictBuilder) WriteTo(w io.Writer) (int64, error) {
+	out, err := b.Build()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(out)
+	return int64(n), err
+}
+
+// A ListBuilder builds the bencoding of a list one element at a time, as
+// in:
+//
+//	b, err := bencode.NewListBuilder().
+//		Str("udp://tracker.example:6969").
+//		List(bencode.NewListBuilder().Str("udp://backup.example:6969")).
+//		Build()
+//
+// Elements are encoded straight into the builder's buffer as they are added,
+// without an intermediate List. A value that cannot be encoded is reported
+// by Build, along with its index.
+type ListBuilder struct {
+	buf []byte // encoding of the elements so far, after the 'l'
+	n   int    // number of elements
+	err error  // first error adding an element
+}
+
+// NewListBuilder returns an empty ListBuilder.
+func NewListBuilder() *ListBuilder {
+	return &ListBuilder{buf: []byte{'l'}}
+}
+
+func (b *ListBuilder) add(value []byte) *ListBuilder {
+	b.buf = append(b.buf, value...)
+	b.n++
+	return b
+}
+
+func (b *ListBuilder) fail(err error) *ListBuilder {
+	if b.err == nil {
+		b.err = fmt.Errorf("bencode: list element %d: %w", b.n, err)
+	}
+	b.n++
+	return b
+}
+
+// Str adds a byte string element.
+func (b *ListBuilder) Str(v string) *ListBuilder {
+	b.buf = appendString(b.buf, v)
+	b.n++
+	return b
+}
+
+// Bytes adds a byte string element.
+func (b *ListBuilder) Bytes(v []byte) *ListBuilder {
+	b.buf = appendBytes(b.buf, v)
+	b.n++
+	return b
+}
+
+// Int adds an integer element.
+func (b *ListBuilder) Int(v int64) *ListBuilder {
+	b.buf = appendInt(b.buf, v)
+	b.n++
+	return b
+}
+
+// Uint adds an integer element.
+func (b *ListBuilder) Uint(v uint64) *ListBuilder {
+	b.buf = appendUint(b.buf, v)
+	b.n++
+	return b
+}
+
+// Bool adds the integer element 1 if v is true, and 0 otherwise.
+func (b *ListBuilder) Bool(v bool) *ListBuilder {
+	if v {
+		return b.add([]byte("i1e"))
+	}
+	return b.add([]byte("i0e"))
+}
+
+// Dict adds the dictionary built by d as an element.
+func (b *ListBuilder) Dict(d *DictBuilder) *ListBuilder {
+	v, err := d.Build()
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.add(v)
+}
+
+// List adds the list built by l as an element.
+func (b *ListBuilder) List(l *ListBuilder) *ListBuilder {
+	if l.err != nil {
+		return b.fail(l.err)
+	}
+	b.buf = append(append(b.buf, l.buf...), 'e')
+	b.n++
+	return b
+}
+
+// Value adds the bencoding of v, as returned by Marshal, as an element.
+func (b *ListBuilder) Value(v interface{}) *ListBuilder {
+	e, err := Marshal(v)
+	if err != nil {
+		return b.fail(err)
+	}
+	return b.add(e)
+}
+
+// Build returns the bencoding of the list, or the first error adding an
+// element to it.
+func (b *ListBuilder) Build() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	out := make([]byte, len(b.buf), len(b.buf)+1)
+	copy(out, b.buf)
+	return append(out, 'e'), nil
+}
+
+// WriteTo writes the bencoding of the list to w, implementing io.WriterTo.
+// Nothing is written if Build would return an error.
+func (b *ListBuilder) WriteTo(w io.Writer) (int64, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.buf = append(b.buf, 'e')
+	n, err := w.Write(b.buf)
+	b.buf = b.buf[:len(b.buf)-1]
+	return int64(n), err
+}
+
+// appendString appends the bencoding of the byte string s to b.
+func appendString(b []byte, s string) []byte {
+	b = strconv.AppendInt(b, int64(len(s)), 10)
+	b = append(b, ':')
+	return append(b, s...)
+}
+
+// appendBytes appends the bencoding of the byte string v to b.
+func appendBytes(b []byte, v []byte) []byte {
+	b = strconv.AppendInt(b, int64(len(v)), 10)
+	b = append(b, ':')
+	return append(b, v...)
+}
+
+// appendInt appends the bencoding of the integer v to b.
+func appendInt(b []byte, v int64) []byte {
+	b = append(b, 'i')
+	b = strconv.AppendInt(b, v, 10)
+	return append(b, 'e')
+}
+
+// appendUint appends the bencoding of the integer v to b.
+func appendUint(b []byte, v uint64) []byte {
+	b = append(b, 'i')
+	b = strconv.AppendUint(b, v, 10)
+	return append(b, 'e')
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var dictBuilderTests = []struct {
+	builder  *DictBuilder
+	expected string
+}{
+	{NewDictBuilder(), "de"},
+	{NewDictBuilder().Str("failure reason", "denied").Int("interval", 1800), "d14:failure reason6:denied8:intervali1800ee"},
+	{NewDictBuilder().Int("min interval", 900).Int("interval", 1800).Uint("complete", 18446744073709551615), "d8:completei18446744073709551615e8:intervali1800e12:min intervali900ee"},
+	{NewDictBuilder().Bytes("peers", []byte{10, 0, 0, 1, 0x1a, 0xe1}).Bool("private", true).Bool("seed", false), "d5:peers6:\x0a\x00\x00\x01\x1a\xe17:privatei1e4:seedi0ee"},
+	{NewDictBuilder().Dict("files", NewDictBuilder().Int("b", 2).Int("a", 1)).Value("info", RawBytes("le")), "d5:filesd1:ai1e1:bi2ee4:infolee"},
+	{NewDictBuilder().Value("peers", []string{"a"}).Value("x", Dict{"z": 1}), "d5:peersl1:ae1:xd1:zi1eee"},
+}
+
+func TestDictBuilder(t *testing.T) {
+	for _, test := range dictBuilderTests {
+		got, err := test.builder.Build()
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != test.expected {
+			t.Errorf("\ngot:      %q\nexpected: %q", got, test.expected)
+		}
+
+		var buf bytes.Buffer
+		n, err := test.builder.WriteTo(&buf)
+		if err != nil {
+			t.Error(err)
+		} else if buf.String() != test.expected || n != int64(len(test.expected)) {
+			t.Errorf("\ngot:      %q (%d bytes)\nexpected: %q", buf.String(), n, test.expected)
+		}
+	}
+}
+
+func TestDictBuilderError(t *testing.T) {
+	tests := []struct {
+		builder  *DictBuilder
+		expected string
+	}{
+		{NewDictBuilder().Str("a", "x").Int("a", 1), `bencode: duplicate key "a"`},
+		{NewDictBuilder().Value("ratio", 0.5).Value("x", 1.5), `bencode: value for key "ratio": bencode: unsupported float value 0.5`},
+		{NewDictBuilder().Dict("info", NewDictBuilder().Value("x", RawBytes{})), `bencode: value for key "info": bencode: value for key "x": bencode: empty RawBytes`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		_, err := test.builder.WriteTo(&buf)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("\ngot:      %v\nexpected: %s", err, test.expected)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("unexpected output %q", buf.String())
+		}
+	}
+
+	_, err := NewDictBuilder().Value("x", RawBytes{}).Build()
+	if !errors.Is(err, errEmptyRawBytes) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", err, errEmptyRawBytes)
+	}
+}
+
+var listBuilderTests = []struct {
+	builder  *ListBuilder
+	expected string
+}{
+	{NewListBuilder(), "le"},
+	{NewListBuilder().Str("a").Bytes([]byte("bc")).Int(-1).Uint(2).Bool(true).Bool(false), "l1:a2:bci-1ei2ei1ei0ee"},
+	{NewListBuilder().List(NewListBuilder().Str("udp://a")).List(NewListBuilder().Str("udp://b").Str("udp://c")), "ll7:udp://ael7:udp://b7:udp://cee"},
+	{NewListBuilder().Dict(NewDictBuilder().Str("ip", "10.0.0.1").Int("port", 6881)).Value(Dict{"a": List{}}), "ld2:ip8:10.0.0.14:porti6881eed1:aleee"},
+}
+
+func TestListBuilder(t *testing.T) {
+	for _, test := range listBuilderTests {
+		got, err := test.builder.Build()
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != test.expected {
+			t.Errorf("\ngot:      %q\nexpected: %q", got, test.expected)
+		}
+
+		var w writeCounter
+		n, err := test.builder.WriteTo(&w)
+		if err != nil {
+			t.Error(err)
+		} else if w.String() != test.expected || n != int64(len(test.expected)) || w.writes != 1 {
+			t.Errorf("\ngot:      %q (%d bytes, %d writes)\nexpected: %q", w.String(), n, w.writes, test.expected)
+		}
+	}
+
+	tiers := NewListBuilder().Str("udp://a")
+	got, err := NewDictBuilder().List("announce-list", NewListBuilder().List(tiers)).Build()
+	if expected := "d13:announce-listll7:udp://aeee"; err != nil || string(got) != expected {
+		t.Errorf("\ngot:      %q, %v\nexpected: %q", got, err, expected)
+	}
+}
+
+func TestListBuilderError(t *testing.T) {
+	tests := []struct {
+		builder  *ListBuilder
+		expected string
+	}{
+		{NewListBuilder().Str("a").Value(0.5).Value(1.5), "bencode: list element 1: bencode: unsupported float value 0.5"},
+		{NewListBuilder().List(NewListBuilder().Int(1).Value(RawBytes{})), "bencode: list element 0: bencode: list element 1: bencode: empty RawBytes"},
+		{NewListBuilder().Dict(NewDictBuilder().Int("a", 1).Int("a", 2)), `bencode: list element 0: bencode: duplicate key "a"`},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		_, err := test.builder.WriteTo(&buf)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("\ngot:      %v\nexpected: %s", err, test.expected)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("unexpected output %q", buf.String())
+		}
+	}
+
+	_, err := NewDictBuilder().List("x", NewListBuilder().Value(RawBytes{})).Build()
+	if !errors.Is(err, errEmptyRawBytes) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", err, errEmptyRawBytes)
+	}
+}
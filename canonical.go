@@ -0,0 +1,91 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// MarshalCanonical returns the canonical bencoding of v: the same value
+// always has the same encoding, byte for byte, as needed to compute the
+// infohash of an info dictionary or the payload of a BEP 44 signature.
+//
+// Values are encoded as by Marshal, with the keys of every dictionary in
+// sorted order. As Marshalers and RawBytes are written verbatim, the result
+// is then checked to hold a single value with dictionary keys in strictly
+// increasing order and integers without leading zeros or a negative zero;
+// output that is not canonical is reported as a *SyntaxError.
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	e := encodeState{w: buf}
+	if err := e.marshal(v); err != nil {
+		return nil, err
+	}
+
+	b := buf.Bytes()
+	d := decodeState{data: b, mark: -1}
+	if err := d.canonical(); err != nil {
+		return nil, err
+	}
+	if d.off < len(b) {
+		return nil, d.syntaxError("trailing data after value")
+	}
+	return b, nil
+}
+
+// canonical consumes the next value, reporting a *SyntaxError if it is not
+// in canonical form. Integers are validated as they are read, leaving the
+// order of dictionary keys to be checked.
+func (d *decodeState) canonical() error {
+	c, err := d.peekValue()
+	if err != nil {
+		return err
+	}
+
+	switch c {
+	case 'l':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		d.off++
+		for {
+			ok, err := d.more()
+			if err != nil || !ok {
+				return err
+			}
+			if err := d.canonical(); err != nil {
+				return err
+			}
+		}
+
+	case 'd':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		d.off++
+		var prev []byte
+		for first := true; ; first = false {
+			ok, err := d.more()
+			if err != nil || !ok {
+				return err
+			}
+			key, err := d.readKeyBytes()
+			if err != nil {
+				return err
+			}
+			if !first && bytes.Compare(key, prev) <= 0 {
+				return d.syntaxError("dict key " + strconv.Quote(string(key)) + " out of order")
+			}
+			prev = append(prev[:0], key...)
+			if err := d.canonical(); err != nil {
+				return err
+			}
+		}
+	}
+	return d.skip()
+}
@@ -0,0 +1,45 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import "testing"
+
+func TestMarshalCanonical(t *testing.T) {
+	v := Dict{
+		"info": Dict{
+			"name":         "a",
+			"piece length": int64(16384),
+			"files":        List{Dict{"path": List{"b"}, "length": int64(1)}},
+		},
+		"announce": "udp://tracker",
+	}
+	expected := "d8:announce13:udp://tracker4:infod5:filesld6:lengthi1e4:pathl1:beee4:name1:a12:piece lengthi16384eee"
+	for i := 0; i < 10; i++ {
+		got, err := MarshalCanonical(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != expected {
+			t.Fatalf("\ngot:      %s\nexpected: %s", got, expected)
+		}
+	}
+}
+
+func TestMarshalCanonicalErrors(t *testing.T) {
+	tests := []interface{}{
+		RawBytes("d1:b0:1:a0:e"),
+		RawBytes("d1:a0:1:a0:e"),
+		RawBytes("li03ee"),
+		RawBytes("i1ei2e"),
+		Dict{"info": RawBytes("i-0e")},
+	}
+	for _, v := range tests {
+		if _, err := MarshalCanonical(v); err == nil {
+			t.Errorf("%s: expected an error", v)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%s: unexpected error %v", v, err)
+		}
+	}
+}
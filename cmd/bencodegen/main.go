@@ -0,0 +1,373 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+// Bencodegen generates MarshalBencode and UnmarshalBencode methods for
+// struct types, so that they are encoded without the reflection the bencode
+// package otherwise falls back to for structs.
+//
+// It is meant to be run by go generate, with a directive such as
+//
+//	//go:generate bencodegen -type=Announce,Scrape
+//
+// in a file of the package declaring the types. For each package directory
+// given, or the current directory by default, it writes the methods of the
+// named types to announce_bencode.go, named after the first type, or to the
+// file given by -output.
+//
+// The generated methods follow the field tags described in the bencode
+// package documentation, supporting key names, "-", and the omitempty and
+// required options. Fields of string, []byte, bool and integer types are
+// written inline; fields of other types are encoded with bencode.Marshal,
+// and all fields are decoded with a bencode.Decoder. Unlike Unmarshal, the
+// generated UnmarshalBencode stops at the first value that cannot be stored
+// in its field. Embedded structs and the other tag options are not
+// supported.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names; must be set")
+	output    = flag.String("output", "", "output file name; default <dir>/<type>_bencode.go")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: bencodegen -type T[,T...] [-output file] [directory]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("bencodegen: ")
+	flag.Usage = usage
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if args := flag.Args(); len(args) > 0 {
+		dir = args[0]
+	}
+	types := strings.Split(*typeNames, ",")
+
+	src, err := generate(dir, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := *output
+	if out == "" {
+		out = filepath.Join(dir, strings.ToLower(types[0])+"_bencode.go")
+	}
+	if err := os.WriteFile(out, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the source of a file declaring the methods of the named
+// struct types of the package in dir.
+func generate(dir string, types []string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected one package in %s, found %d", dir, len(pkgs))
+	}
+
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+
+	g := &generator{}
+	for _, name := range types {
+		st := findStruct(pkg, name)
+		if st == nil {
+			return nil, fmt.Errorf("struct type %s not found in %s", name, dir)
+		}
+		fields, err := structFields(name, st)
+		if err != nil {
+			return nil, err
+		}
+		g.marshal(name, fields)
+		g.unmarshal(name, fields)
+	}
+	return g.source(pkg.Name)
+}
+
+// findStruct returns the struct type declared as name in pkg, or nil if
+// there is none.
+func findStruct(pkg *ast.Package, name string) *ast.StructType {
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if ts.Name.Name != name {
+					continue
+				}
+				st, _ := ts.Type.(*ast.StructType)
+				return st
+			}
+		}
+	}
+	return nil
+}
+
+// A field describes a struct field that is encoded as a dictionary entry.
+type field struct {
+	name      string // Go name
+	key       string // dictionary key
+	typ       string // type expression
+	omitEmpty bool
+	required  bool
+}
+
+// structFields returns the fields of the struct type name, described by st,
+// in key order.
+func structFields(name string, st *ast.StructType) ([]field, error) {
+	var fields []field
+	for _, f := range st.Fields.List {
+		var tag string
+		if f.Tag != nil {
+			s, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(s).Get("bencode")
+		}
+		if tag == "-" {
+			continue
+		}
+		key, opts, _ := strings.Cut(tag, ",")
+
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported", name)
+		}
+		var omitEmpty, required bool
+		if opts != "" {
+			for _, opt := range strings.Split(opts, ",") {
+				switch opt {
+				case "omitempty":
+					omitEmpty = true
+				case "required":
+					required = true
+				default:
+					return nil, fmt.Errorf("%s.%s: unsupported option %q", name, f.Names[0].Name, opt)
+				}
+			}
+		}
+
+		typ := exprString(f.Type)
+		for _, n := range f.Names {
+			if !n.IsExported() {
+				continue
+			}
+			if omitEmpty && emptyCheck("v."+n.Name, typ) == "" {
+				return nil, fmt.Errorf("%s.%s: omitempty is not supported for type %s", name, n.Name, typ)
+			}
+			k := key
+			if k == "" {
+				k = n.Name
+			}
+			fields = append(fields, field{
+				name:      n.Name,
+				key:       k,
+				typ:       typ,
+				omitEmpty: omitEmpty,
+				required:  required,
+			})
+		}
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].key < fields[j].key })
+	for i := 1; i < len(fields); i++ {
+		if fields[i].key == fields[i-1].key {
+			return nil, fmt.Errorf("%s: duplicate key %q", name, fields[i].key)
+		}
+	}
+	return fields, nil
+}
+
+// exprString returns the source form of the type expression x.
+func exprString(x ast.Expr) string {
+	var buf bytes.Buffer
+	format.Node(&buf, token.NewFileSet(), x)
+	return buf.String()
+}
+
+// emptyCheck returns an expression reporting whether the value x of type
+// typ is not empty, or "" if the type is not one omitempty supports.
+func emptyCheck(x, typ string) string {
+	switch {
+	case typ == "string":
+		return x + ` != ""`
+	case typ == "bool":
+		return x
+	case isInt(typ) || isUint(typ):
+		return x + " != 0"
+	case strings.HasPrefix(typ, "[]"), strings.HasPrefix(typ, "map["):
+		return "len(" + x + ") != 0"
+	case strings.HasPrefix(typ, "*"):
+		return x + " != nil"
+	}
+	return ""
+}
+
+func isInt(typ string) bool {
+	switch typ {
+	case "int", "int8", "int16", "int32", "int64":
+		return true
+	}
+	return false
+}
+
+func isUint(typ string) bool {
+	switch typ {
+	case "uint", "uint8", "uint16", "uint32", "uint64", "uintptr":
+		return true
+	}
+	return false
+}
+
+// A generator accumulates the methods of a generated file.
+type generator struct {
+	buf     bytes.Buffer
+	strconv bool // whether strconv is used
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// source returns the formatted source of the file, in package pkg.
+func (g *generator) source(pkg string) ([]byte, error) {
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by bencodegen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n\n", pkg)
+	fmt.Fprintf(&src, "import (\n\t\"bytes\"\n")
+	if g.strconv {
+		fmt.Fprintf(&src, "\t\"strconv\"\n")
+	}
+	fmt.Fprintf(&src, "\n\t\"github.com/chihaya/bencode\"\n)\n")
+	src.Write(g.buf.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// marshal writes the MarshalBencode method of the type name.
+func (g *generator) marshal(name string, fields []field) {
+	g.printf("\n// MarshalBencode implements bencode.Marshaler.\n")
+	g.printf("func (v %s) MarshalBencode() ([]byte, error) {\n", name)
+	g.printf("b := make([]byte, 0, 64)\n")
+	g.printf("b = append(b, 'd')\n")
+	for _, f := range fields {
+		x := "v." + f.name
+		cond := ""
+		if f.omitEmpty {
+			cond = emptyCheck(x, f.typ)
+		} else if strings.HasPrefix(f.typ, "*") {
+			cond = x + " != nil" // nil pointers are left out
+		}
+		if cond != "" {
+			g.printf("if %s {\n", cond)
+		}
+		g.printf("b = append(b, %q...)\n", strconv.Itoa(len(f.key))+":"+f.key)
+		g.marshalValue(x, f.typ)
+		if cond != "" {
+			g.printf("}\n")
+		}
+	}
+	g.printf("b = append(b, 'e')\n")
+	g.printf("return b, nil\n")
+	g.printf("}\n")
+}
+
+// marshalValue writes the code appending the encoding of x, of type typ,
+// to b.
+func (g *generator) marshalValue(x, typ string) {
+	switch {
+	case typ == "string" || typ == "[]byte":
+		g.strconv = true
+		g.printf("b = strconv.AppendInt(b, int64(len(%s)), 10)\n", x)
+		g.printf("b = append(b, ':')\n")
+		g.printf("b = append(b, %s...)\n", x)
+	case typ == "bool":
+		g.printf("if %s {\nb = append(b, \"i1e\"...)\n} else {\nb = append(b, \"i0e\"...)\n}\n", x)
+	case isInt(typ):
+		g.strconv = true
+		g.printf("b = append(strconv.AppendInt(append(b, 'i'), int64(%s), 10), 'e')\n", x)
+	case isUint(typ):
+		g.strconv = true
+		g.printf("b = append(strconv.AppendUint(append(b, 'i'), uint64(%s), 10), 'e')\n", x)
+	default:
+		g.printf("{\n")
+		g.printf("e, err := bencode.Marshal(%s)\n", x)
+		g.printf("if err != nil {\nreturn nil, err\n}\n")
+		g.printf("b = append(b, e...)\n")
+		g.printf("}\n")
+	}
+}
+
+// unmarshal writes the UnmarshalBencode method of the type name.
+func (g *generator) unmarshal(name string, fields []field) {
+	var required []field
+	for _, f := range fields {
+		if f.required {
+			required = append(required, f)
+		}
+	}
+
+	g.printf("\n// UnmarshalBencode implements bencode.Unmarshaler.\n")
+	g.printf("func (v *%s) UnmarshalBencode(data []byte) error {\n", name)
+	if len(required) > 0 {
+		g.printf("var seen [%d]bool\n", len(required))
+	}
+	g.printf("dec := bencode.NewDecoder(bytes.NewReader(data))\n")
+	g.printf("err := dec.DecodeDictFunc(func(key string, dec *bencode.Decoder) error {\n")
+	g.printf("switch key {\n")
+	for _, f := range fields {
+		g.printf("case %q:\n", f.key)
+		for i, r := range required {
+			if r.key == f.key {
+				g.printf("seen[%d] = true\n", i)
+			}
+		}
+		g.printf("return dec.Decode(&v.%s)\n", f.name)
+	}
+	g.printf("}\n")
+	g.printf("return nil\n")
+	g.printf("})\n")
+	g.printf("if err != nil {\nreturn err\n}\n")
+	for i, r := range required {
+		g.printf("if !seen[%d] {\n", i)
+		g.printf("return &bencode.RequiredFieldError{Struct: %q, Field: %q}\n", name, r.key)
+		g.printf("}\n")
+	}
+	g.printf("return nil\n")
+	g.printf("}\n")
+}
@@ -0,0 +1,200 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSource = `package tracker
+
+type Announce struct {
+	Interval    int64    ` + "`bencode:\"interval,required\"`" + `
+	MinInterval uint16   ` + "`bencode:\"min interval,omitempty\"`" + `
+	Peers       []byte   ` + "`bencode:\"peers\"`" + `
+	Warning     string   ` + "`bencode:\"warning message,omitempty\"`" + `
+	Private     bool     ` + "`bencode:\"private\"`" + `
+	Tiers       [][]string
+	Next        *Announce ` + "`bencode:\"next\"`" + `
+	cache       []byte
+	Ignored     int ` + "`bencode:\"-\"`" + `
+}
+`
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tracker.go"), []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := generate(dir, []string{"Announce"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(src)
+	for _, expected := range []string{
+		"// Code generated by bencodegen; DO NOT EDIT.",
+		"package tracker",
+		"func (v Announce) MarshalBencode() ([]byte, error) {",
+		"func (v *Announce) UnmarshalBencode(data []byte) error {",
+		`b = append(b, "5:Tiers"...)`,
+		`b = append(b, "8:interval"...)`,
+		"if v.MinInterval != 0 {",
+		`if v.Warning != "" {`,
+		"if v.Next != nil {",
+		"e, err := bencode.Marshal(v.Tiers)",
+		`case "peers":`,
+		"return dec.Decode(&v.Peers)",
+		`return &bencode.RequiredFieldError{Struct: "Announce", Field: "interval"}`,
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("expected output to contain %q:\n%s", expected, got)
+		}
+	}
+	for _, unexpected := range []string{"cache", "Ignored"} {
+		if strings.Contains(got, unexpected) {
+			t.Errorf("expected output not to contain %q:\n%s", unexpected, got)
+		}
+	}
+
+	// Keys are written in sorted order.
+	if strings.Index(got, `"5:Tiers"`) > strings.Index(got, `"8:interval"`) {
+		t.Errorf("expected keys in sorted order:\n%s", got)
+	}
+}
+
+// roundTripTest checks the generated methods of Announce against the
+// reflection-based encoding of a copy of the type without them.
+const roundTripTest = `package tracker
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/chihaya/bencode"
+)
+
+type plainAnnounce Announce
+
+func TestRoundTrip(t *testing.T) {
+	for _, v := range []Announce{
+		{
+			Interval:    1800,
+			MinInterval: 900,
+			Peers:       []byte("\x0a\x00\x00\x01\x1a\xe1"),
+			Warning:     "slow down",
+			Private:     true,
+			Tiers:       [][]string{{"a", "b"}, {"c"}},
+			Next:        &Announce{Interval: 60, Peers: []byte("x"), Tiers: [][]string{{"d"}}},
+		},
+		{Peers: []byte("y"), Tiers: [][]string{}},
+	} {
+		got, err := bencode.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := bencode.Marshal(plainAnnounce(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, expected) {
+			t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+		}
+
+		var back Announce
+		if err := bencode.Unmarshal(got, &back); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(back, v) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", back, v)
+		}
+	}
+}
+`
+
+// TestGenerateBuild builds the generated methods in a module of their own,
+// alongside a copy of the bencode package, and runs roundTripTest there.
+func TestGenerateBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping build of generated code in short mode")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	root := t.TempDir()
+	lib := filepath.Join(root, "bencode")
+	files, err := filepath.Glob(filepath.Join("..", "..", "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(filepath.Join(lib, filepath.Base(file)), string(b))
+	}
+	write(filepath.Join(lib, "go.mod"), "module github.com/chihaya/bencode\n\ngo 1.23\n")
+
+	dir := filepath.Join(root, "tracker")
+	write(filepath.Join(dir, "go.mod"), "module example.com/tracker\n\ngo 1.23\n\n"+
+		"require github.com/chihaya/bencode v0.0.0\n\n"+
+		"replace github.com/chihaya/bencode => ../bencode\n")
+	write(filepath.Join(dir, "tracker.go"), testSource)
+	write(filepath.Join(dir, "roundtrip_test.go"), roundTripTest)
+	src, err := generate(dir, []string{"Announce"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(dir, "announce_bencode.go"), string(src))
+
+	cmd := exec.Command(goTool, "test", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=on", "GOFLAGS=-mod=mod", "GOPROXY=off", "GOWORK=off", "GOTOOLCHAIN=local")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("%v\n%s\ngenerated source:\n%s", err, out, src)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"package p\ntype T struct{ A int `bencode:\",inline\"` }\n", `T.A: unsupported option "inline"`},
+		{"package p\ntype T struct{ A struct{} `bencode:\",omitempty\"` }\n", "T.A: omitempty is not supported for type struct{}"},
+		{"package p\ntype U struct{}\ntype T struct{ U }\n", "T: embedded fields are not supported"},
+		{"package p\ntype T struct{ A, B int `bencode:\"x\"` }\n", `T: duplicate key "x"`},
+		{"package p\ntype T int\n", "struct type T not found"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "p.go"), []byte(test.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := generate(dir, []string{"T"})
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("\ngot:      %v\nexpected: %s", err, test.err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// A TimeoutError is returned by DecodeContext when decoding is stopped by
+// the cancellation of its context or by a read deadline.
+type TimeoutError struct {
+	Err    error // context.Canceled, context.DeadlineExceeded or the read error
+	Offset int64 // offset of the value that was being decoded
+}
+
+func (e *TimeoutError) Error() string {
+	return "bencode: decode stopped: " + e.Err.Error()
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether decoding was stopped by a deadline rather than by
+// the cancellation of its context, as net.Error does.
+func (e *TimeoutError) Timeout() bool {
+	return !errors.Is(e.Err, context.Canceled)
+}
+
+// A deadlineReader is an input, such as a net.Conn, whose blocked reads can
+// be interrupted.
+type deadlineReader interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// DecodeContext is like Decode, but stops when ctx is cancelled or its
+// deadline passes, returning a *TimeoutError. If the input has a
+// SetReadDeadline method, as a net.Conn does, a read blocked waiting for
+// input is interrupted, and the read deadline is cleared on return;
+// otherwise ctx is checked before each read. After a timeout, the Decoder is
+// left positioned at the start of the value, with the input read so far
+// retained, so that decoding can be tried again. The value pointed to by v
+// may have been partly filled in.
+func (dec *Decoder) DecodeContext(ctx context.Context, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return &TimeoutError{Err: err, Offset: dec.d.offset()}
+	}
+	if dr, ok := dec.d.r.(deadlineReader); ok {
+		deadline, _ := ctx.Deadline()
+		dr.SetReadDeadline(deadline)
+		interrupted := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			dr.SetReadDeadline(time.Unix(1, 0))
+			close(interrupted)
+		})
+		defer func() {
+			if !stop() {
+				<-interrupted
+			}
+			dr.SetReadDeadline(time.Time{})
+		}()
+	}
+
+	start := dec.d.offset()
+	dec.d.ctx = ctx
+	rewound, err := dec.rewindOn(func() error { return dec.Decode(v) }, func(err error) bool {
+		return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+			errors.Is(err, os.ErrDeadlineExceeded)
+	})
+	dec.d.ctx = nil
+	if !rewound {
+		return err
+	}
+	if cerr := ctx.Err(); cerr != nil {
+		err = cerr
+	}
+	return &TimeoutError{Err: err, Offset: start}
+}
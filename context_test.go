@@ -0,0 +1,82 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecoderDecodeContextDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go server.Write([]byte("i1ed5:peers"))
+
+	dec := NewDecoder(client)
+	var n int64
+	if err := dec.DecodeContext(context.Background(), &n); err != nil || n != 1 {
+		t.Fatalf("got %d, %v; expected 1", n, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var v Dict
+	err := dec.DecodeContext(ctx, &v)
+	var terr *TimeoutError
+	if !errors.As(err, &terr) || !terr.Timeout() || terr.Offset != 3 {
+		t.Fatalf("got %#v, expected a *TimeoutError at offset 3", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v, expected a deadline error", err)
+	}
+
+	// The rest of the value arrives, and decoding is tried again.
+	go server.Write([]byte("le4:spami1ee"))
+	v = nil
+	if err := dec.DecodeContext(context.Background(), &v); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Dict{"peers": List{}, "spam": int64(1)}); !reflect.DeepEqual(v, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", v, expected)
+	}
+}
+
+// cancelReader cancels a context when it is first read from.
+type cancelReader struct {
+	r      *strings.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return r.r.Read(p[:min(len(p), 4)])
+}
+
+func TestDecoderDecodeContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dec := NewDecoder(&cancelReader{strings.NewReader("l1:a1:be"), cancel})
+
+	var v []string
+	err := dec.DecodeContext(ctx, &v)
+	expected := &TimeoutError{Err: context.Canceled, Offset: 0}
+	if !reflect.DeepEqual(err, expected) || expected.Timeout() {
+		t.Fatalf("\ngot:      %#v\nexpected: %#v", err, expected)
+	}
+	if err := dec.DecodeContext(ctx, &v); !reflect.DeepEqual(err, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", err, expected)
+	}
+
+	v = nil
+	if err := dec.Decode(&v); err != nil || !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Errorf("got %#v, %v after cancellation", v, err)
+	}
+}
@@ -5,131 +5,2173 @@
 package bencode
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"encoding"
 	"errors"
 	"io"
+	"iter"
+	"math"
+	"math/big"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // A Decoder reads bencoded objects from an input stream.
 type Decoder struct {
-	r *bufio.Reader
+	d         decodeState
+	depth     int          // number of containers entered and not yet left
+	resumable bool         // values cut short are rewound, to be decoded again
+	tokens    []tokenFrame // containers begun by Token and not yet ended
+	bound     *valueBound  // the one value a WalkDict callback may read, if any
+	key       []byte       // the key handed to a WalkDict callback
+	err       error        // error skipping a list left early, returned by later reads
 }
 
-// NewDecoder returns a new decoder that reads from r.
-func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r: bufio.NewReader(r)}
+// NewDecoder returns a new decoder that reads from r, configured by opts.
+//
+// The decoder introduces its own buffering and may read data from r beyond
+// the bencoded values requested.
+func NewDecoder(r io.Reader, opts ...Option) *Decoder {
+	dec := &Decoder{d: decodeState{r: r, mark: -1}}
+	for _, opt := range opts {
+		if opt.dec != nil {
+			opt.dec(dec)
+		}
+	}
+	return dec
+}
+
+// Reset discards any buffered data and makes the Decoder read from r, as if
+// newly created but with its configuration retained. The Decoder's buffer is
+// kept for reuse, so pooled Decoders read new messages without allocating.
+func (dec *Decoder) Reset(r io.Reader) {
+	d := &dec.d
+	d.data, d.off, d.base, d.mark = d.data[:0], 0, 0, -1
+	d.r, d.err, d.savedError = r, nil, nil
+	dec.depth, dec.tokens, dec.bound, dec.err = 0, dec.tokens[:0], nil, nil
+}
+
+// Decode reads the next bencoded value from its input and stores it in the
+// value pointed to by v. Each call consumes exactly one value, so Decode may
+// be called repeatedly to read a stream of concatenated values. It returns
+// io.EOF if the input is exhausted before the value begins, and
+// io.ErrUnexpectedEOF if it ends in the middle of one.
+//
+// A value that could not be stored in v is consumed in full before its
+// *UnmarshalTypeError is returned, so the stream remains positioned at the
+// start of the next value.
+//
+// See the documentation for Unmarshal for details about the conversion of
+// bencode into a Go value.
+func (dec *Decoder) Decode(v interface{}) error {
+	if dec.resumable {
+		_, err := dec.rewindOn(func() error { return dec.decode(v) }, isShortInput)
+		return err
+	}
+	return dec.decode(v)
+}
+
+func (dec *Decoder) decode(v interface{}) error {
+	if err := dec.begin(); err != nil {
+		return err
+	}
+	if err := checkTarget(v); err != nil {
+		return err
+	}
+	return dec.d.unmarshal(v)
+}
+
+// Skip consumes the next bencoded value from the input without decoding
+// it. It does not allocate, and byte strings are dropped as they are read.
+// Like Decode, it returns io.EOF if the input is exhausted before the value
+// begins.
+func (dec *Decoder) Skip() error {
+	if dec.resumable {
+		_, err := dec.rewindOn(dec.skip, isShortInput)
+		return err
+	}
+	return dec.skip()
+}
+
+func (dec *Decoder) skip() error {
+	if err := dec.begin(); err != nil {
+		return err
+	}
+	return dec.d.skip()
+}
+
+// Resumable causes Decode and Skip to leave the Decoder positioned at the
+// start of the value when the input ends before it does, with the input
+// read so far retained, rather than part of the way through it. The error,
+// io.EOF or io.ErrUnexpectedEOF, is then forgotten, so that the value can be
+// decoded once more of it has arrived, as when reading from a buffer that
+// is filled as the data comes in. The value pointed to by v may have been
+// partly filled in by the attempt. Likewise, More reports false when no
+// more input has arrived yet, without the end of the input being recorded.
+func (dec *Decoder) Resumable() {
+	dec.resumable = true
+}
+
+func isShortInput(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
+
+// rewindOn runs decode, which reads the next value, and if it fails with an
+// error for which rewind reports true, restores the Decoder to the start of
+// the value and forgets the read error. It reports whether the Decoder was
+// rewound, and returns the error.
+func (dec *Decoder) rewindOn(decode func() error, rewind func(error) bool) (bool, error) {
+	d := &dec.d
+	start, prevMark := d.offset(), d.mark
+	if prevMark < 0 {
+		d.mark = start
+	}
+	var frame tokenFrame
+	f := dec.tokenFrame()
+	if f != nil {
+		frame = *f
+	}
+	var used bool
+	if dec.bound != nil {
+		used = dec.bound.used
+	}
+
+	err := decode()
+	d.mark = prevMark
+	if err == nil || !rewind(err) {
+		return false, err
+	}
+
+	d.off, d.err, d.savedError = int(start-d.base), nil, nil
+	if f != nil {
+		*f = frame
+	}
+	if dec.bound != nil {
+		dec.bound.used = used
+	}
+	return true, err
+}
+
+// DecodeDictFunc reads the next bencoded value, which must be a
+// dictionary, and calls fn for each of its entries in turn. fn is called
+// with the Decoder positioned at the entry's value, which it may read with
+// Decode or Skip, or walk with a nested call to DecodeDictFunc. Values fn
+// leaves unread are skipped, so that only the entries of interest are ever
+// decoded. fn must not read past the value it is given.
+//
+// If fn returns an error, DecodeDictFunc stops and returns it, leaving the
+// rest of the dictionary unread.
+func (dec *Decoder) DecodeDictFunc(fn func(key string, dec *Decoder) error) error {
+	if err := dec.enter('d', dictType); err != nil {
+		return err
+	}
+	defer func() { dec.depth-- }()
+
+	for {
+		ok, err := dec.d.more()
+		if err != nil || !ok {
+			return err
+		}
+
+		key, err := dec.d.readKey()
+		if err != nil {
+			return err
+		}
+		if err := dec.call(func() error { return fn(key, dec) }); err != nil {
+			return err
+		}
+	}
+}
+
+// DecodeListFunc reads the next bencoded value, which must be a list, and
+// calls fn for each of its elements in turn, with the Decoder positioned at
+// the element. Elements are handed to fn as soon as they are read, so that
+// long lists, such as the peers of an announce response, can be processed
+// while the rest of the input is still arriving. As with DecodeDictFunc,
+// elements fn leaves unread are skipped, fn must not read past the element
+// it is given, and an error returned by fn stops the iteration.
+func (dec *Decoder) DecodeListFunc(fn func(dec *Decoder) error) error {
+	if err := dec.enter('l', listType); err != nil {
+		return err
+	}
+	defer func() { dec.depth-- }()
+
+	for {
+		ok, err := dec.d.more()
+		if err != nil || !ok {
+			return err
+		}
+		if err := dec.call(func() error { return fn(dec) }); err != nil {
+			return err
+		}
+	}
+}
+
+// A valueBound limits a Decoder to reading the value of a dictionary entry
+// handed to a WalkDict callback.
+type valueBound struct {
+	depth int  // the Decoder's depth at the value
+	used  bool // the value has been begun
+}
+
+// WalkDict reads the next bencoded value, which must be a dictionary, and
+// calls fn for each of its entries in turn, as DecodeDictFunc does. The
+// Decoder fn is given is bounded to the entry's value: once fn has begun
+// reading the value, with Decode, Skip, Token or a nested walk, reading on
+// past it returns io.EOF, as at the end of the input, so that fn can hand
+// the Decoder to code that reads until the end. Whatever part of the value
+// fn leaves unread is skipped. key is only valid until fn returns.
+func (dec *Decoder) WalkDict(fn func(key []byte, dec *Decoder) error) error {
+	if err := dec.enter('d', dictType); err != nil {
+		return err
+	}
+	outer := dec.bound
+	b := &valueBound{depth: dec.depth}
+	defer func() { dec.depth, dec.bound = dec.depth-1, outer }()
+
+	for {
+		ok, err := dec.d.more()
+		if err != nil || !ok {
+			return err
+		}
+
+		key, err := dec.d.readKeyBytes()
+		if err != nil {
+			return err
+		}
+		dec.key = append(dec.key[:0], key...)
+		b.used, dec.bound = false, b
+		if err := fn(dec.key, dec); err != nil {
+			return err
+		}
+		if err := dec.finishBound(b); err != nil {
+			return err
+		}
+	}
+}
+
+// List reads the next bencoded value, which must be a list, and returns an
+// iterator over its elements, yielding for each a Decoder bounded to the
+// element as WalkDict's callbacks are, so that long lists can be consumed
+// one element at a time:
+//
+//	for dec, err := range dec.List() {
+//		if err != nil {
+//			return err
+//		}
+//		if err := dec.Decode(&peer); err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// Elements left unread are skipped, and so is the rest of the list when
+// the loop is broken out of; an error doing so is returned by the
+// Decoder's next read. Iteration ends at the end of the list, or after
+// yielding the first error.
+func (dec *Decoder) List() iter.Seq2[*Decoder, error] {
+	return func(yield func(*Decoder, error) bool) {
+		if err := dec.enter('l', listType); err != nil {
+			yield(nil, err)
+			return
+		}
+		outer := dec.bound
+		b := &valueBound{depth: dec.depth}
+		defer func() { dec.depth, dec.bound = dec.depth-1, outer }()
+
+		for {
+			ok, err := dec.d.more()
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !ok {
+				return
+			}
+			b.used, dec.bound = false, b
+			more := yield(dec, nil)
+			if err := dec.finishBound(b); err != nil {
+				if more {
+					yield(nil, err)
+				}
+				return
+			}
+			if !more {
+				if err := dec.skipRest(); err != nil {
+					dec.err = err
+				}
+				return
+			}
+		}
+	}
+}
+
+// skipRest consumes the rest of the list the Decoder is within, up to and
+// including its end.
+func (dec *Decoder) skipRest() error {
+	for {
+		ok, err := dec.d.more()
+		if err != nil || !ok {
+			return err
+		}
+		if err := dec.d.skip(); err != nil {
+			return err
+		}
+	}
+}
+
+// finishBound consumes whatever part of the value bounded by b has not been
+// read.
+func (dec *Decoder) finishBound(b *valueBound) error {
+	if !b.used {
+		return dec.d.skip()
+	}
+	for len(dec.tokens) > 0 && dec.tokens[len(dec.tokens)-1].depth > b.depth {
+		f := &dec.tokens[len(dec.tokens)-1]
+		if f.value {
+			if err := dec.d.skip(); err != nil {
+				return err
+			}
+			f.value = false
+		}
+		ok, err := dec.d.more()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			dec.tokens = dec.tokens[:len(dec.tokens)-1]
+			dec.depth--
+			continue
+		}
+		if f.dict {
+			if err := dec.d.skipKey(); err != nil {
+				return err
+			}
+		}
+		if err := dec.d.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enter consumes the start of the next value, which must be a list or a
+// dictionary as given by c, and increments the depth. A value of another
+// kind is skipped and reported as not fitting in a Go value of type t.
+func (dec *Decoder) enter(c byte, t reflect.Type) error {
+	if err := dec.begin(); err != nil {
+		return err
+	}
+	got, err := dec.d.peekValue()
+	if err != nil {
+		return err
+	}
+	if got != c {
+		err := &UnmarshalTypeError{Value: describe(got), Type: t, Offset: dec.d.offset()}
+		if serr := dec.d.skip(); serr != nil {
+			return serr
+		}
+		return err
+	}
+	if err := dec.d.enter(); err != nil {
+		return err
+	}
+	dec.d.off++
+	dec.depth++
+	return nil
+}
+
+// call runs fn, which is handed the next value, and skips the value if fn
+// did not read it.
+func (dec *Decoder) call(fn func() error) error {
+	start := dec.d.offset()
+	if err := fn(); err != nil {
+		return err
+	}
+	if dec.d.offset() == start {
+		return dec.d.skip()
+	}
+	return nil
+}
+
+// begin ensures that input is buffered before a value is read, as buffer
+// does. Within a dictionary or list begun by Token, it records that a key,
+// value or element is being read.
+func (dec *Decoder) begin() error {
+	if dec.err != nil {
+		return dec.err
+	}
+	dec.d.depth = dec.depth
+	if f := dec.tokenFrame(); f != nil {
+		switch {
+		case !f.dict:
+			f.n++
+		case f.value:
+			f.value = false
+		default:
+			f.beginEntry(nil)
+		}
+	}
+	if err := dec.buffer(); err != nil {
+		return err
+	}
+	if b := dec.bound; b != nil && b.depth == dec.depth {
+		b.used = true
+	}
+	return nil
+}
+
+// buffer ensures that input is buffered. At the top level, it returns
+// io.EOF if there is none, as it does past the value a WalkDict callback is
+// bounded to.
+func (dec *Decoder) buffer() error {
+	if b := dec.bound; b != nil && b.depth == dec.depth && b.used {
+		return io.EOF
+	}
+	if dec.d.off < len(dec.d.data) {
+		return nil
+	}
+	if dec.depth > 0 {
+		return dec.d.fillMore()
+	}
+	return dec.d.fill()
+}
+
+// UseBytes causes the Decoder to store byte strings in interface{} values
+// as []byte instead of string. Dictionary keys are always decoded as
+// strings.
+func (dec *Decoder) UseBytes() {
+	dec.d.useBytes = true
+}
+
+// An IntOverflow selects how a Decoder handles integers too large for the
+// values they are decoded into.
+type IntOverflow int
+
+const (
+	// IntOverflowError reports an *UnmarshalTypeError. This is the default.
+	IntOverflowError IntOverflow = iota
+
+	// IntOverflowSaturate stores the integer closest to the decoded value
+	// that the destination can hold.
+	IntOverflowSaturate
+
+	// IntOverflowBigInt stores integers that do not fit in an int64 as
+	// *big.Int when decoding into interface{} values. Other destinations
+	// report an *UnmarshalTypeError.
+	IntOverflowBigInt
+)
+
+// SetIntOverflow sets how the Decoder handles integers too large for their
+// destination. Integers decoded into *big.Int values never overflow.
+func (dec *Decoder) SetIntOverflow(mode IntOverflow) {
+	dec.d.overflow = mode
+}
+
+// CaseInsensitiveKeys causes the Decoder to match dictionary keys against
+// the keys of its destination without regard to case, for input produced
+// by clients that do not spell keys consistently. When decoding into a map
+// that already has an entry whose key differs from the input key only in
+// case, the value is stored in that entry.
+func (dec *Decoder) CaseInsensitiveKeys() {
+	dec.d.foldKeys = true
+}
+
+// DisallowUnknownFields causes the Decoder to report an *UnknownFieldError
+// when a dictionary decoded into a struct has an entry that matches none of
+// its fields and the struct has no inline map to store it in.
+func (dec *Decoder) DisallowUnknownFields() {
+	dec.d.disallowUnknown = true
+}
+
+// SetKeyFunc makes the Decoder derive the keys of struct fields without a
+// name in their tag by calling fn with the field's name, as for
+// Encoder.SetKeyFunc. Passing nil restores the default of using the name
+// unchanged.
+func (dec *Decoder) SetKeyFunc(fn func(fieldName string) string) {
+	dec.d.keys = newKeyFunc(fn)
+}
+
+// A DecodeHook transforms the values of struct fields as they are decoded.
+// It is called with the type of the field and the dictionary entry's value
+// as it would be stored in an interface{}: an int64, string, List or Dict.
+// If the value it returns is assignable to the field, it is stored there;
+// otherwise it is decoded into the field as if it were the entry's value, so
+// a DecodeHook that does not apply to a field returns data unchanged. An
+// error stops neither the hook from being called for other fields nor the
+// decoding, which returns the first one.
+type DecodeHook func(to reflect.Type, data interface{}) (interface{}, error)
+
+// SetDecodeHook makes the Decoder pass the value of each struct field
+// through hook, for instance to parse a field's string form into a richer
+// type. Passing nil removes the hook.
+func (dec *Decoder) SetDecodeHook(hook DecodeHook) {
+	dec.d.hook = hook
+}
+
+// A Progress reports how far a Decoder has got through its input.
+//
+// Path is the path Decoder.Path reports, that of the last token returned by
+// Token. Within a value read in full by Decode or Skip it stays that of the
+// value: the keys and indices of what is inside are not kept track of, as
+// that would slow every decode, but Depth counts the dictionaries and lists
+// being read there too.
+type Progress struct {
+	Offset int64  // number of bytes of input consumed
+	Read   int64  // number of bytes read from the input, consumed or buffered
+	Depth  int    // number of dictionaries and lists being read
+	Path   string // path of the last token returned by Token
+}
+
+// SetProgress makes the Decoder call fn each time it reads from its input,
+// so that the progress of a long decode can be reported. If fn returns an
+// error, as a watchdog might on finding a decode to have run too long, it
+// is treated as a read error: the decode in progress stops and returns it,
+// and so does every later read, until the Decoder is Reset. Passing nil
+// removes the callback.
+func (dec *Decoder) SetProgress(fn func(p Progress) error) {
+	if fn == nil {
+		dec.d.progress = nil
+		return
+	}
+	dec.d.progress = func(p Progress) error {
+		p.Path = dec.Path()
+		return fn(p)
+	}
+}
+
+// SetMaxDepth limits how deeply the Decoder lets dictionaries and lists
+// nest, those begun by Token or DecodeDictFunc included, so that input
+// nested deeper is reported as a *SyntaxError. A maxDepth of 0 restores the
+// limit of DefaultMaxDepth.
+func (dec *Decoder) SetMaxDepth(maxDepth int) {
+	dec.d.maxDepth = maxDepth
+}
+
+// SetMaxBytes limits the input the Decoder reads from its reader to
+// maxBytes bytes in all, or since it was last Reset, so that a value not
+// ending within them is reported as ErrTooLarge, as with UnmarshalReader.
+func (dec *Decoder) SetMaxBytes(maxBytes int64) {
+	dec.d.limited, dec.d.limit = true, maxBytes
+}
+
+// More reports whether there is another value in the input, so that a
+// stream of values can be read until it ends:
+//
+//	for dec.More() {
+//		if err := dec.Decode(&v); err != nil {
+//			return err
+//		}
+//	}
+//
+// More returns false once the input is exhausted. Read errors other than
+// io.EOF make it return true, leaving them to be reported by Decode.
+//
+// Within a dictionary or list begun by Token, More instead reports whether
+// it has another element.
+func (dec *Decoder) More() bool {
+	if dec.err != nil {
+		return true
+	}
+	if dec.tokenFrame() != nil {
+		c, err := dec.d.peek()
+		return err != nil || c != 'e'
+	}
+	if b := dec.bound; b != nil && b.depth == dec.depth {
+		return !b.used
+	}
+	if dec.d.off < len(dec.d.data) {
+		return true
+	}
+	err := dec.d.fill()
+	if err == io.EOF && dec.resumable {
+		dec.d.err = nil
+	}
+	return err != io.EOF
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer.
+// The reader is valid until the next call to Decode.
+//
+// When bencoded values are followed by data in another format, the rest of
+// the input can be read with io.MultiReader(dec.Buffered(), r).
+func (dec *Decoder) Buffered() io.Reader {
+	return bytes.NewReader(dec.d.data[dec.d.off:])
+}
+
+// Values returns an iterator over the values remaining in the input, each
+// decoded as if into an interface{} value. Iteration ends at the end of the
+// input, or after yielding the first error.
+func (dec *Decoder) Values() iter.Seq2[interface{}, error] {
+	return func(yield func(interface{}, error) bool) {
+		for dec.More() {
+			var v interface{}
+			err := dec.Decode(&v)
+			if !yield(v, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Unmarshal parses the bencoded data and stores the result in the value
+// pointed to by v. Any input after the value is ignored; see UnmarshalStrict
+// and UnmarshalPrefix.
+//
+// If v implements Unmarshaler, its UnmarshalBencode method is called with
+// the encoding of the value. Otherwise, v must be one of *string, *[]byte, a
+// pointer to any integer type, *big.Int, *List, *[]interface{}, *[]string,
+// *Dict, *OrderedDict, *map[string]interface{}, *interface{}, *LazyString,
+// *bool, *time.Duration, *time.Time, a pointer to a value of a defined type
+// whose underlying type is one of these, a pointer to a slice or array, a
+// pointer to a map whose keys are of string kind, or a pointer to a struct.
+// The elements of slices, arrays and maps, and the fields of structs, are
+// decoded in turn as if into a pointer to their type. A pointer to a pointer
+// is decoded into the value it points to, which is allocated first if the
+// pointer is nil.
+//
+// Slices and arrays are decoded from lists, except that those of bytes are
+// decoded from byte strings. An array of bytes, such as a [20]byte infohash,
+// must be decoded from a byte string of the same length. A bool is decoded
+// from the integer 0 or 1, or from the byte string "0" or "1". A
+// time.Duration is decoded from an integer of seconds, as Marshal encodes
+// it. A time.Time is decoded from an integer of seconds since the Unix
+// epoch, or of milliseconds for struct fields with the "unixmilli" option.
+//
+// Otherwise, if v implements encoding.TextUnmarshaler, its UnmarshalText
+// method is called with the contents of a byte string, and failing that, if
+// v implements encoding.BinaryUnmarshaler, its UnmarshalBinary method.
+//
+// A dictionary is decoded into a struct by storing each entry in the
+// exported field whose key matches the entry's key, as described for
+// Marshal. Entries without a matching field are stored in the struct's
+// inline map if it has one, and are ignored otherwise. If the dictionary has
+// no entry for a field with the "required" option, Unmarshal returns a
+// *RequiredFieldError once the rest of the input has been decoded. A field
+// with a "default=" option is decoded from the option's value if the
+// dictionary has no entry for it, the value being read as an integer for
+// fields of integer type or time.Time, as 1 or 0 for bool fields given true
+// or false, and as a byte string for fields decoded from byte strings:
+//
+//	Interval int64 `bencode:"interval,default=1800"`
+//
+// A default that does not fit its field is a mistake in the program, which
+// Unmarshal reports as an error without decoding the struct.
+//
+// Fields may also be given validation options, which Unmarshal checks as it
+// decodes them, returning a *ValidationError for the first value that
+// violates one. "min=" and "max=" bound the value of integers and the length
+// of strings, slices and maps; "len=" requires an exact length; and
+// "nonempty" rejects the values that "omitempty" would leave out. A bound
+// that is not an integer is a mistake in the program, which Unmarshal
+// reports as an error without decoding the struct.
+//
+//	PieceLength int64  `bencode:"piece length,min=16384"`
+//	Pieces      []byte `bencode:"pieces,nonempty"`
+//
+// To unmarshal into an interface value holding nil, Unmarshal stores one of
+// these in it:
+//
+//	int64, for bencoded integers
+//	string, for bencoded byte strings
+//	List, for bencoded lists
+//	Dict, for bencoded dictionaries
+//
+// Values nested inside lists and dictionaries are stored the same way,
+// except that those nested in an OrderedDict store dictionaries as
+// OrderedDicts. If the interface value holds a non-nil pointer, Unmarshal
+// decodes into the value it points to instead.
+//
+// v may also be a non-nil map, in which case the entries of the bencoded
+// dictionary are added to it. This allows a map to be reused across calls;
+// entries already present are kept unless the input replaces them. Entries
+// holding a non-nil pointer are decoded into, which makes it possible to
+// pick out values with a *RawBytes or other typed destination.
+//
+// Malformed input is reported as a *SyntaxError, as is input with
+// dictionaries and lists nested more than DefaultMaxDepth deep, and input
+// that ends in the middle of a value as io.ErrUnexpectedEOF. If a value
+// cannot be stored in the destination, Unmarshal skips it, carries on with
+// the rest of the input and returns an *UnmarshalTypeError describing the
+// first such value.
+func Unmarshal(data []byte, v interface{}) error {
+	if err := checkTarget(v); err != nil {
+		return err
+	}
+	d := decodeState{data: data, mark: -1}
+	return d.unmarshal(v)
+}
+
+// UnmarshalStrict is like Unmarshal, but returns a *SyntaxError if data
+// holds anything after the bencoded value.
+func UnmarshalStrict(data []byte, v interface{}) error {
+	n, err := UnmarshalPrefix(data, v)
+	if err == nil && n < len(data) {
+		err = &SyntaxError{"trailing data after value", int64(n)}
+	}
+	return err
+}
+
+// UnmarshalPrefix is like Unmarshal, but also returns the number of bytes
+// of data the bencoded value occupies. This locates the end of a value
+// embedded in a larger frame; data[n:] is the input that follows it.
+func UnmarshalPrefix(data []byte, v interface{}) (n int, err error) {
+	if err := checkTarget(v); err != nil {
+		return 0, err
+	}
+	d := decodeState{data: data, mark: -1}
+	err = d.unmarshal(v)
+	return d.off, err
+}
+
+// ErrTooLarge is returned by UnmarshalReader, and by Decoders created with
+// WithMaxBytes, if a value does not end within the permitted number of bytes.
+var ErrTooLarge = errors.New("bencode: input exceeds size limit")
+
+// UnmarshalReader reads a bencoded value from r and stores it in the value
+// pointed to by v, like Unmarshal. It reads at most maxBytes bytes from r,
+// returning ErrTooLarge if the value does not end within them, which, with
+// the limit of DefaultMaxDepth on nesting, makes it suitable for decoding
+// untrusted input such as HTTP response bodies.
+func UnmarshalReader(r io.Reader, v interface{}, maxBytes int64) error {
+	if err := checkTarget(v); err != nil {
+		return err
+	}
+	d := decodeState{r: r, mark: -1, limited: true, limit: maxBytes}
+	if err := d.fill(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return d.unmarshal(v)
+}
+
+// UnmarshalNoCopy is like Unmarshal, but byte strings decoded into []byte
+// values refer to the corresponding bytes of data rather than being copied.
+// This avoids allocating for large binary fields such as piece hashes, at
+// the cost of data having to be left unmodified for as long as the decoded
+// values are in use.
+func UnmarshalNoCopy(data []byte, v interface{}) error {
+	if err := checkTarget(v); err != nil {
+		return err
+	}
+	d := decodeState{data: data, mark: -1, noCopy: true}
+	return d.unmarshal(v)
+}
+
+// UnmarshalAs parses the bencoded data into a new value of type T and
+// returns it, like Unmarshal.
+func UnmarshalAs[T any](data []byte) (T, error) {
+	var v T
+	err := Unmarshal(data, &v)
+	return v, err
+}
+
+// UnmarshalDict parses the bencoded dictionary in data and returns it.
+func UnmarshalDict(data []byte) (Dict, error) {
+	dict := NewDict()
+	if err := Unmarshal(data, dict); err != nil {
+		return nil, err
+	}
+	return dict, nil
+}
+
+// A Span locates a value within bencoded input, as data[Start:End].
+type Span struct {
+	Start, End int
+}
+
+// UnmarshalSpans parses the bencoded dictionary in data like UnmarshalDict,
+// and also returns the span of each of its values. The spans give access to
+// values exactly as they were encoded, such as for hashing the info
+// dictionary of a torrent.
+func UnmarshalSpans(data []byte) (Dict, map[string]Span, error) {
+	d := decodeState{data: data, mark: -1}
+	c, err := d.peekValue()
+	if err != nil {
+		return nil, nil, err
+	}
+	if c != 'd' {
+		return nil, nil, &UnmarshalTypeError{Value: describe(c), Type: dictType, Offset: d.offset()}
+	}
+
+	dict, spans := NewDict(), make(map[string]Span)
+	d.off++ // 'd'
+	for {
+		ok, err := d.more()
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			break
+		}
+
+		key, err := d.readKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		start := d.off
+		if dict[key], err = d.value(); err != nil {
+			return nil, nil, err
+		}
+		spans[key] = Span{start, d.off}
+	}
+
+	if d.savedError != nil {
+		return nil, nil, d.savedError
+	}
+	return dict, spans, nil
+}
+
+// checkTarget returns an *InvalidUnmarshalError if values cannot be decoded
+// into v.
+func checkTarget(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Map) && !rv.IsNil() {
+		return nil
+	}
+	return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+}
+
+// Unmarshaler is the interface implemented by objects that can unmarshal a
+// bencoded description of themselves. UnmarshalBencode must copy the data
+// if it wishes to retain it after returning.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+// A SyntaxError describes malformed bencoded input.
+type SyntaxError struct {
+	msg    string // description of the error
+	Offset int64  // error occurred after reading Offset bytes
+}
+
+func (e *SyntaxError) Error() string {
+	return "bencode: " + e.msg + " at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// An UnmarshalTypeError describes a bencoded value that could not be stored
+// in a Go value of a specific type.
+type UnmarshalTypeError struct {
+	Value  string       // description of the value: "integer", "string", "list" or "dict"
+	Type   reflect.Type // type of the Go value it could not be assigned to
+	Offset int64        // offset of the value in the input
+	Struct string       // name of the struct type containing the field
+	Field  string       // the full path from the struct to the field
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Struct != "" || e.Field != "" {
+		return "bencode: cannot unmarshal " + e.Value + " into Go struct field " + e.Struct + "." + e.Field + " of type " + e.Type.String()
+	}
+	return "bencode: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
+}
+
+// A RequiredFieldError describes a dictionary missing the entry for a struct
+// field with the required option.
+type RequiredFieldError struct {
+	Struct string // name of the struct type containing the field
+	Field  string // the full path from the struct to the field
+}
+
+func (e *RequiredFieldError) Error() string {
+	return "bencode: missing required key for Go struct field " + e.Struct + "." + e.Field
+}
+
+// An UnknownFieldError describes a dictionary entry that matches none of the
+// fields of the struct it is decoded into, reported by Decoders on which
+// DisallowUnknownFields has been called.
+type UnknownFieldError struct {
+	Struct string // name of the struct type
+	Field  string // the full path from the struct to the entry
+	Offset int64  // offset of the entry's key in the input
+}
+
+func (e *UnknownFieldError) Error() string {
+	return "bencode: unknown key " + strconv.Quote(e.Field) + " for Go struct " + e.Struct
+}
+
+// A ValidationError describes a value that violates a validation option of
+// the struct field it is decoded into.
+type ValidationError struct {
+	Rule   string // the option violated, such as "min=16384"
+	Struct string // name of the struct type containing the field
+	Field  string // the full path from the struct to the field
+	Offset int64  // offset of the value in the input
+}
+
+func (e *ValidationError) Error() string {
+	return "bencode: value of Go struct field " + e.Struct + "." + e.Field + " violates " + e.Rule
+}
+
+// An InvalidUnmarshalError describes an invalid argument passed to
+// Unmarshal. (The argument must be a non-nil pointer or map.)
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "bencode: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr && e.Type.Kind() != reflect.Map {
+		return "bencode: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "bencode: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+const maxInt = uint64(^uint(0) >> 1)
+
+var (
+	int64Type  = reflect.TypeOf(int64(0))
+	stringType = reflect.TypeOf("")
+	dictType   = reflect.TypeOf(Dict(nil))
+	listType   = reflect.TypeOf(List(nil))
+	timeType   = reflect.TypeOf(time.Time{})
+	boolType   = reflect.TypeOf(false)
+
+	unmarshalerType       = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// minRead is the smallest number of bytes a decodeState asks its reader
+// for.
+const minRead = 512
+
+// maxConsecutiveEmptyReads is the number of times a decodeState retries a
+// reader that returns neither data nor an error.
+const maxConsecutiveEmptyReads = 100
+
+// decodeState holds the input and read position of a decoding in progress.
+// When reading from a stream, data is a window of the input that is
+// refilled from r as it is consumed.
+type decodeState struct {
+	data []byte // buffered input; data[off:] is unread
+	off  int
+	r    io.Reader // source of further input, or nil
+	err  error     // sticky error from r
+	base int64     // offset of data[0] in the input
+	mark int64     // offset of the earliest input to keep buffered, or -1
+
+	noCopy   bool        // byte strings may alias data, which is never refilled
+	useBytes bool        // generic byte strings are []byte rather than string
+	overflow IntOverflow // handling of integers too large for their destination
+	foldKeys bool        // keys match their destination case-insensitively
+
+	disallowUnknown bool       // struct entries without a field are an error
+	keys            *keyFunc   // mapping of untagged struct field names, or nil
+	hook            DecodeHook // transformation of struct field values, or nil
+
+	limited bool  // whether reads from r are limited
+	limit   int64 // the most input to read from r, if limited
+
+	sr   *io.SectionReader // r, if the input is an io.ReaderAt
+	ra   io.ReaderAt       // input of LazyString values, once needed
+	lazy bool              // generic byte strings are LazyString values
+
+	ordered bool // generic dictionaries are OrderedDicts
+
+	depth    int // number of dictionaries and lists being read
+	maxDepth int // most dictionaries and lists a value may nest, or 0 for the default
+
+	ctx      context.Context      // checked before each read, during DecodeContext
+	progress func(Progress) error // called after each read
+
+	savedError error // first type error, reported once the value is consumed
+}
+
+func (d *decodeState) offset() int64 {
+	return d.base + int64(d.off)
+}
+
+func (d *decodeState) syntaxError(msg string) error {
+	return &SyntaxError{msg: msg, Offset: d.offset()}
+}
+
+// DefaultMaxDepth is the deepest that dictionaries and lists may be nested
+// within one another in decoded input, unless set otherwise by
+// WithMaxDepth.
+const DefaultMaxDepth = 10000
+
+// enter records that the dictionary or list about to be read nests one
+// level deeper, returning a *SyntaxError if that is deeper than allowed. As
+// each level is read by a call of its own, the limit keeps deeply nested
+// input from exhausting the stack.
+func (d *decodeState) enter() error {
+	max := d.maxDepth
+	if max <= 0 {
+		max = DefaultMaxDepth
+	}
+	if d.depth >= max {
+		return d.syntaxError("exceeded max depth")
+	}
+	d.depth++
+	return nil
+}
+
+// leave records that a dictionary or list begun by enter has been read.
+func (d *decodeState) leave() {
+	d.depth--
+}
+
+// fill discards the consumed part of the buffer and reads more input into
+// it. It returns io.EOF if no input remains.
+func (d *decodeState) fill() error {
+	if d.r == nil {
+		return io.EOF
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if d.ctx != nil {
+		if err := d.ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	discard := d.off
+	if d.mark >= 0 && int(d.mark-d.base) < discard {
+		discard = int(d.mark - d.base)
+	}
+	if discard > 0 {
+		n := copy(d.data, d.data[discard:])
+		d.data = d.data[:n]
+		d.base += int64(discard)
+		d.off -= discard
+	}
+	if cap(d.data)-len(d.data) < minRead {
+		buf := make([]byte, len(d.data), 2*cap(d.data)+minRead)
+		copy(buf, d.data)
+		d.data = buf
+	}
+
+	buf := d.data[len(d.data):cap(d.data)]
+	if d.limited {
+		remaining := d.limit - d.base - int64(len(d.data))
+		if remaining <= 0 {
+			return ErrTooLarge
+		}
+		if int64(len(buf)) > remaining {
+			buf = buf[:remaining]
+		}
+	}
+
+	for i := 0; i < maxConsecutiveEmptyReads; i++ {
+		n, err := d.r.Read(buf)
+		d.data = d.data[:len(d.data)+n]
+		if err != nil {
+			d.err = err
+		}
+		if n > 0 && d.progress != nil {
+			p := Progress{Offset: d.offset(), Read: d.base + int64(len(d.data)), Depth: d.depth}
+			if perr := d.progress(p); perr != nil {
+				d.err = perr
+				return perr
+			}
+		}
+		if n > 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return io.ErrNoProgress
+}
+
+// fillMore is like fill, but reports running out of input as
+// io.ErrUnexpectedEOF, as it happens only in the middle of a value.
+func (d *decodeState) fillMore() error {
+	err := d.fill()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// saveError records err, the first of which is returned once the value
+// being decoded has been consumed in full.
+func (d *decodeState) saveError(err error) {
+	if d.savedError == nil {
+		d.savedError = err
+	}
+}
+
+// mismatch records that the value starting with c cannot be stored in a Go
+// value of type t and skips over it, so that decoding can carry on with the
+// rest of the input.
+func (d *decodeState) mismatch(c byte, t reflect.Type) error {
+	d.saveError(&UnmarshalTypeError{Value: describe(c), Type: t, Offset: d.offset()})
+	return d.skip()
+}
+
+// describe names the kind of value that starts with c.
+func describe(c byte) string {
+	return kindOf(c).String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// peek returns the next byte of input without consuming it.
+func (d *decodeState) peek() (byte, error) {
+	for d.off >= len(d.data) {
+		if err := d.fillMore(); err != nil {
+			return 0, err
+		}
+	}
+	return d.data[d.off], nil
+}
+
+// peekValue returns the first byte of the next value without consuming it.
+func (d *decodeState) peekValue() (byte, error) {
+	c, err := d.peek()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c == 'i', c == 'l', c == 'd', isDigit(c):
+		return c, nil
+	}
+	return 0, d.syntaxError("invalid character " + strconv.QuoteRune(rune(c)) + " looking for beginning of value")
+}
+
+// readUntil consumes the input up to and including term and returns the
+// bytes before it. The result is only valid until the next read.
+func (d *decodeState) readUntil(term byte) ([]byte, error) {
+	scanned := 0
+	for {
+		if i := bytes.IndexByte(d.data[d.off+scanned:], term); i >= 0 {
+			i += d.off + scanned
+			b := d.data[d.off:i]
+			d.off = i + 1
+			return b, nil
+		}
+		scanned = len(d.data) - d.off
+		if err := d.fillMore(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readN consumes and returns the next n bytes of input. The result is only
+// valid until the next read.
+func (d *decodeState) readN(n int) ([]byte, error) {
+	for len(d.data)-d.off < n {
+		if err := d.fillMore(); err != nil {
+			return nil, err
+		}
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+// readIntBytes consumes an integer value and returns its digits, which have
+// been validated against the rules of BEP 3.
+func (d *decodeState) readIntBytes() ([]byte, error) {
+	d.off++ // 'i'
+	start := d.offset()
+	b, err := d.readUntil('e')
+	if err != nil {
+		return nil, err
+	}
+	if !validInt(b) {
+		return nil, &SyntaxError{"invalid integer " + strconv.Quote(string(b)), start}
+	}
+	return b, nil
+}
+
+// readInt consumes an integer value that must fit in a signed integer of
+// the given size. Values that do not fit in the destination type t are
+// saturated or recorded as an error, according to the overflow mode.
+func (d *decodeState) readInt(bits uint, t reflect.Type) (int64, error) {
+	start := d.offset()
+	b, err := d.readIntBytes()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := parseInt(b)
+	if ok && n<<(64-bits)>>(64-bits) == n {
+		return n, nil
+	}
+
+	if d.overflow == IntOverflowSaturate {
+		if b[0] == '-' {
+			return -1 << (bits - 1), nil
+		}
+		return 1<<(bits-1) - 1, nil
+	}
+	d.saveError(&UnmarshalTypeError{Value: "integer " + string(b), Type: t, Offset: start})
+	return 0, nil
+}
+
+// readInt64 consumes an integer value that must fit in an int64, returning
+// the error at once if it does not, rather than once the value is consumed.
+func (d *decodeState) readInt64() (int64, error) {
+	n, err := d.readInt(64, int64Type)
+	if err == nil {
+		err, d.savedError = d.savedError, nil
+	}
+	return n, err
+}
+
+// readBool consumes an integer 0 or 1, or a byte string "0" or "1", that
+// starts with c. Other values are recorded as an error.
+func (d *decodeState) readBool(c byte) (bool, error) {
+	start := d.offset()
+	var value string
+	var b []byte
+	var err error
+	if c == 'i' {
+		b, err = d.readIntBytes()
+		value = "integer " + string(b)
+	} else {
+		b, err = d.readString()
+		value = "string " + strconv.Quote(string(b))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch string(b) {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	d.saveError(&UnmarshalTypeError{Value: value, Type: boolType, Offset: start})
+	return false, nil
+}
+
+// readUint consumes an integer value that must fit in an unsigned integer
+// of the given size. Values that do not fit in the destination type t are
+// saturated or recorded as an error, according to the overflow mode.
+func (d *decodeState) readUint(bits uint, t reflect.Type) (uint64, error) {
+	start := d.offset()
+	b, err := d.readIntBytes()
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	ok := b[0] != '-'
+	if ok {
+		n, ok = parseUint(b)
+	}
+	if ok && (bits == 64 || n>>bits == 0) {
+		return n, nil
+	}
+
+	if d.overflow == IntOverflowSaturate {
+		if b[0] == '-' {
+			return 0, nil
+		}
+		return math.MaxUint64 >> (64 - bits), nil
+	}
+	d.saveError(&UnmarshalTypeError{Value: "integer " + string(b), Type: t, Offset: start})
+	return 0, nil
+}
+
+// readBigInt consumes an integer value of any size.
+func (d *decodeState) readBigInt() (*big.Int, error) {
+	b, err := d.readIntBytes()
+	if err != nil {
+		return nil, err
+	}
+	n, _ := new(big.Int).SetString(string(b), 10)
+	return n, nil
+}
+
+// readString consumes a byte string value and returns its contents. The
+// result is only valid until the next read.
+func (d *decodeState) readString() ([]byte, error) {
+	n, err := d.readStringLen()
+	if err != nil {
+		return nil, err
+	}
+	return d.readN(n)
+}
+
+// readStringLen consumes the length prefix of a byte string value.
+func (d *decodeState) readStringLen() (int, error) {
+	start := d.offset()
+	b, err := d.readUntil(':')
+	if err != nil {
+		return 0, err
+	}
+	n, ok := uint64(0), validInt(b) && b[0] != '-'
+	if ok {
+		n, ok = parseUint(b)
+	}
+	if !ok || n > maxInt {
+		return 0, &SyntaxError{"invalid string length " + strconv.Quote(string(b)), start}
+	}
+	return int(n), nil
+}
+
+// discard consumes the next n bytes of input. Unless they must be kept
+// buffered for rawValue, they are dropped as they are read, or not read at
+// all if the input is an io.ReaderAt.
+func (d *decodeState) discard(n int64) error {
+	for {
+		buffered := int64(len(d.data) - d.off)
+		if n <= buffered {
+			d.off += int(n)
+			return nil
+		}
+		d.off = len(d.data)
+		n -= buffered
+
+		if d.sr != nil && d.mark < 0 {
+			d.base += int64(len(d.data)) + n
+			d.data, d.off = d.data[:0], 0
+			if d.base > d.sr.Size() {
+				return io.ErrUnexpectedEOF
+			}
+			_, err := d.sr.Seek(d.base, io.SeekStart)
+			return err
+		}
+		if err := d.fillMore(); err != nil {
+			return err
+		}
+	}
+}
+
+// bytes returns the contents of a byte string returned by readString as a
+// slice that remains valid after the next read.
+func (d *decodeState) bytes(b []byte) []byte {
+	if d.noCopy {
+		return b[:len(b):len(b)]
+	}
+	return append([]byte(nil), b...)
+}
+
+// readKey consumes a dictionary key.
+func (d *decodeState) readKey() (string, error) {
+	b, err := d.readKeyBytes()
+	return string(b), err
+}
+
+// readKeyBytes consumes a dictionary key and returns its contents. The
+// result is only valid until the next read.
+func (d *decodeState) readKeyBytes() ([]byte, error) {
+	c, err := d.peek()
+	if err != nil {
+		return nil, err
+	}
+	if !isDigit(c) {
+		return nil, d.syntaxError("dict key is not a string")
+	}
+	return d.readString()
 }
 
-// Decode unmarshals the next bencoded value in the stream.
-func (dec *Decoder) Decode() (interface{}, error) {
-	return unmarshal(dec.r)
+// more reports whether the list or dictionary being read has another
+// element, consuming its terminator if it does not.
+func (d *decodeState) more() (bool, error) {
+	c, err := d.peek()
+	if err != nil {
+		return false, err
+	}
+	if c == 'e' {
+		d.off++
+		return false, nil
+	}
+	return true, nil
 }
 
-// Unmarshal deserializes and returns the bencoded value in buf.
-func Unmarshal(buf []byte) (interface{}, error) {
-	r := bufio.NewReader(bytes.NewBuffer(buf))
-	return unmarshal(r)
+// unmarshal consumes the next value and stores it in v, returning the first
+// error encountered along the way.
+func (d *decodeState) unmarshal(v interface{}) error {
+	err := d.decode(v)
+	if err == nil {
+		err = d.savedError
+	}
+	d.savedError = nil
+	return err
 }
 
-// unmarshal reads bencoded values from a bufio.Reader
-func unmarshal(r *bufio.Reader) (interface{}, error) {
-	tok, err := r.ReadByte()
+// decode consumes the next value and stores it in the value pointed to by
+// v, or in v itself if it is a map.
+func (d *decodeState) decode(v interface{}) error {
+	c, err := d.peekValue()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	switch tok {
-	case 'i':
-		return readTerminatedInt(r, 'e')
+	switch v := v.(type) {
+	case Unmarshaler:
+		b, err := d.rawValue()
+		if err != nil {
+			return err
+		}
+		if err := v.UnmarshalBencode(b); err != nil {
+			d.saveError(err)
+		}
+		return nil
 
-	case 'l':
-		list := NewList()
-		for {
-			ok, err := readTerminator(r, 'e')
-			if err != nil {
-				return nil, err
-			} else if ok {
-				break
+	case *interface{}:
+		if isPointer(*v) {
+			return d.decode(*v)
+		}
+		var err error
+		*v, err = d.value()
+		return err
+
+	case *string:
+		if isDigit(c) {
+			b, err := d.readString()
+			*v = string(b)
+			return err
+		}
+
+	case *[]byte:
+		if isDigit(c) {
+			b, err := d.readString()
+			*v = d.bytes(b)
+			return err
+		}
+
+	case *int:
+		if c == 'i' {
+			n, err := d.readInt(strconv.IntSize, reflect.TypeOf(*v))
+			*v = int(n)
+			return err
+		}
+
+	case *int8:
+		if c == 'i' {
+			n, err := d.readInt(8, reflect.TypeOf(*v))
+			*v = int8(n)
+			return err
+		}
+
+	case *int16:
+		if c == 'i' {
+			n, err := d.readInt(16, reflect.TypeOf(*v))
+			*v = int16(n)
+			return err
+		}
+
+	case *int32:
+		if c == 'i' {
+			n, err := d.readInt(32, reflect.TypeOf(*v))
+			*v = int32(n)
+			return err
+		}
+
+	case *int64:
+		if c == 'i' {
+			n, err := d.readInt(64, int64Type)
+			*v = n
+			return err
+		}
+
+	case *uint:
+		if c == 'i' {
+			n, err := d.readUint(strconv.IntSize, reflect.TypeOf(*v))
+			*v = uint(n)
+			return err
+		}
+
+	case *uint8:
+		if c == 'i' {
+			n, err := d.readUint(8, reflect.TypeOf(*v))
+			*v = uint8(n)
+			return err
+		}
+
+	case *uint16:
+		if c == 'i' {
+			n, err := d.readUint(16, reflect.TypeOf(*v))
+			*v = uint16(n)
+			return err
+		}
+
+	case *uint32:
+		if c == 'i' {
+			n, err := d.readUint(32, reflect.TypeOf(*v))
+			*v = uint32(n)
+			return err
+		}
+
+	case *uint64:
+		if c == 'i' {
+			n, err := d.readUint(64, reflect.TypeOf(*v))
+			*v = n
+			return err
+		}
+
+	case *LazyString:
+		if isDigit(c) {
+			return d.readLazyString(v)
+		}
+
+	case *big.Int:
+		if c == 'i' {
+			n, err := d.readBigInt()
+			if err == nil {
+				v.Set(n)
 			}
+			return err
+		}
 
-			v, err := unmarshal(r)
-			if err != nil {
-				return nil, err
+	case *bool:
+		if c == 'i' || isDigit(c) {
+			b, err := d.readBool(c)
+			*v = b
+			return err
+		}
+
+	case *time.Duration: // Assume seconds
+		if c == 'i' {
+			n, err := d.readInt(64, reflect.TypeOf(*v))
+			*v = time.Duration(n) * time.Second
+			return err
+		}
+
+	case *time.Time:
+		if c == 'i' {
+			n, err := d.readInt(64, timeType)
+			*v = time.Unix(n, 0)
+			return err
+		}
+
+	case *List:
+		if c == 'l' {
+			if *v == nil {
+				*v = NewList()
 			}
-			list = append(list, v)
+			l, err := d.appendList((*v)[:0])
+			*v = l
+			return err
 		}
-		return list, nil
 
-	case 'd':
-		dict := NewDict()
-		for {
-			ok, err := readTerminator(r, 'e')
-			if err != nil {
-				return nil, err
-			} else if ok {
-				break
+	case *[]interface{}:
+		if c == 'l' {
+			if *v == nil {
+				*v = NewList()
 			}
+			l, err := d.appendList((*v)[:0])
+			*v = l
+			return err
+		}
 
-			v, err := unmarshal(r)
-			if err != nil {
-				return nil, err
+	case *[]string:
+		if c == 'l' {
+			if *v == nil {
+				*v = make([]string, 0)
 			}
+			l, err := d.appendStrings((*v)[:0])
+			*v = l
+			return err
+		}
 
-			key, ok := v.(string)
-			if !ok {
-				return nil, errors.New("bencode: non-string map key")
+	case *OrderedDict:
+		if c == 'd' {
+			ordered := d.ordered
+			d.ordered = true
+			od, err := d.readOrderedDict((*v)[:0])
+			d.ordered = ordered
+			*v = od
+			return err
+		}
+
+	case *Dict:
+		if c == 'd' {
+			if *v == nil {
+				*v = NewDict()
 			}
+			return d.readDict(*v)
+		}
 
-			dict[key], err = unmarshal(r)
-			if err != nil {
-				return nil, err
+	case *map[string]interface{}:
+		if c == 'd' {
+			if *v == nil {
+				*v = NewDict()
 			}
+			return d.readDict(*v)
+		}
+
+	case Dict:
+		if c == 'd' {
+			return d.readDict(v)
 		}
-		return dict, nil
+		return d.mismatch(c, reflect.TypeOf(v))
+
+	case map[string]interface{}:
+		if c == 'd' {
+			return d.readDict(v)
+		}
+		return d.mismatch(c, reflect.TypeOf(v))
 
 	default:
-		err = r.UnreadByte()
+		return d.decodeReflect(c, reflect.ValueOf(v))
+	}
+
+	return d.mismatch(c, reflect.TypeOf(v).Elem())
+}
+
+// decodeReflect decodes the next value, which starts with c, into the value
+// pointed to by rv, or into rv itself if it is a map. It handles the types
+// decode does not know statically.
+func (d *decodeState) decodeReflect(c byte, rv reflect.Value) error {
+	if u, ok := rv.Interface().(encoding.TextUnmarshaler); ok {
+		if !isDigit(c) {
+			return d.mismatch(c, rv.Type())
+		}
+		b, err := d.readString()
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if err := u.UnmarshalText(b); err != nil {
+			d.saveError(err)
+		}
+		return nil
+	}
+	if u, ok := rv.Interface().(encoding.BinaryUnmarshaler); ok {
+		if !isDigit(c) {
+			return d.mismatch(c, rv.Type())
+		}
+		b, err := d.readString()
+		if err != nil {
+			return err
+		}
+		if err := u.UnmarshalBinary(b); err != nil {
+			d.saveError(err)
+		}
+		return nil
+	}
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+
+	switch t := rv.Type(); rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(t.Elem()))
+		}
+		return d.decode(rv.Interface())
+
+	case reflect.String:
+		if isDigit(c) {
+			b, err := d.readString()
+			rv.SetString(string(b))
+			return err
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if c == 'i' {
+			n, err := d.readInt(uint(t.Bits()), t)
+			rv.SetInt(n)
+			return err
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if c == 'i' {
+			n, err := d.readUint(uint(t.Bits()), t)
+			rv.SetUint(n)
+			return err
+		}
+
+	case reflect.Bool:
+		if c == 'i' || isDigit(c) {
+			b, err := d.readBool(c)
+			rv.SetBool(b)
+			return err
+		}
+
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if isDigit(c) {
+				return d.readByteArray(rv)
+			}
+		} else if c == 'l' {
+			return d.readArray(rv)
+		}
+
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if isDigit(c) {
+				b, err := d.readString()
+				if err == nil {
+					rv.SetBytes(d.bytes(b))
+				}
+				return err
+			}
+		} else if c == 'l' {
+			return d.readSlice(rv)
 		}
 
-		length, err := readTerminatedInt(r, ':')
+	case reflect.Map:
+		if c == 'd' && rv.Type().Key().Kind() == reflect.String {
+			if rv.IsNil() {
+				rv.Set(reflect.MakeMap(rv.Type()))
+			}
+			return d.readMap(rv)
+		}
+
+	case reflect.Struct:
+		if c == 'd' {
+			return d.readStruct(rv)
+		}
+	}
+
+	return d.mismatch(c, rv.Type())
+}
+
+// readSlice consumes a list and stores its elements in s, a slice, reusing
+// its backing array. Each element is decoded into a zero value of s's
+// element type.
+func (d *decodeState) readSlice(s reflect.Value) error {
+	l := s
+	if l.IsNil() {
+		l = reflect.MakeSlice(s.Type(), 0, 0)
+	}
+	l = l.Slice(0, 0)
+	zero := reflect.Zero(s.Type().Elem())
+
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	d.off++ // 'l'
+	for {
+		ok, err := d.more()
+		if err != nil || !ok {
+			s.Set(l)
+			return err
+		}
+
+		l = reflect.Append(l, zero)
+		if err := d.decode(l.Index(l.Len() - 1).Addr().Interface()); err != nil {
+			s.Set(l)
+			return err
+		}
+	}
+}
+
+// readArray consumes a list and stores its elements in a, an array. As with
+// encoding/json, elements beyond the length of a are dropped, and elements
+// of a beyond the length of the list are zeroed.
+func (d *decodeState) readArray(a reflect.Value) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	d.off++ // 'l'
+	for i := 0; ; i++ {
+		ok, err := d.more()
 		if err != nil {
-			return nil, errors.New("bencode: unknown input sequence")
+			return err
+		}
+		if !ok {
+			for ; i < a.Len(); i++ {
+				a.Index(i).SetZero()
+			}
+			return nil
+		}
+
+		if i >= a.Len() {
+			if err := d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decode(a.Index(i).Addr().Interface()); err != nil {
+			return err
 		}
+	}
+}
+
+// readByteArray consumes a byte string and copies it into a, an array of
+// bytes. A string of another length than a is recorded as an error.
+func (d *decodeState) readByteArray(a reflect.Value) error {
+	start := d.offset()
+	b, err := d.readString()
+	if err != nil {
+		return err
+	}
+	if len(b) != a.Len() {
+		d.saveError(&UnmarshalTypeError{
+			Value:  "string of length " + strconv.Itoa(len(b)),
+			Type:   a.Type(),
+			Offset: start,
+		})
+		return nil
+	}
+	reflect.Copy(a, reflect.ValueOf(b))
+	return nil
+}
 
-		buf := make([]byte, length)
-		n, err := r.Read(buf)
+// readMap consumes a dictionary and stores its entries in m, a map with
+// keys of string kind. Each value is decoded into a new element of m's
+// element type.
+func (d *decodeState) readMap(m reflect.Value) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	d.off++ // 'd'
+	for {
+		ok, err := d.more()
+		if err != nil || !ok {
+			return err
+		}
 
+		key, err := d.readKey()
 		if err != nil {
-			return nil, err
-		} else if int64(n) != length {
-			return nil, errors.New("bencode: short read")
+			return err
 		}
+		if err := d.readMapValue(m, key); err != nil {
+			return err
+		}
+	}
+}
+
+// readMapValue consumes a value and stores it in m, a map with keys of
+// string kind, under key.
+func (d *decodeState) readMapValue(m reflect.Value, key string) error {
+	t := m.Type()
+	kv := reflect.ValueOf(key).Convert(t.Key())
+	if d.foldKeys {
+		kv = foldMapKey(m, kv)
+	}
 
-		return string(buf), nil
+	elem := reflect.New(t.Elem())
+	if err := d.decode(elem.Interface()); err != nil {
+		return err
 	}
+	m.SetMapIndex(kv, elem.Elem())
+	return nil
 }
 
-func readTerminator(r *bufio.Reader, term byte) (bool, error) {
-	tok, err := r.ReadByte()
+// readIntOrBigInt consumes an integer value, returning it as an int64 if it
+// fits and as a *big.Int otherwise.
+func (d *decodeState) readIntOrBigInt() (interface{}, error) {
+	b, err := d.readIntBytes()
 	if err != nil {
-		return false, err
-	} else if tok == term {
-		return true, nil
+		return nil, err
 	}
-	return false, r.UnreadByte()
+	if n, ok := parseInt(b); ok {
+		return n, nil
+	}
+	n, _ := new(big.Int).SetString(string(b), 10)
+	return n, nil
 }
 
-func readTerminatedInt(r *bufio.Reader, term byte) (int64, error) {
-	buf, err := r.ReadSlice(term)
+// rawValue consumes the next value and returns its encoding. The result is
+// only valid until the next read.
+func (d *decodeState) rawValue() ([]byte, error) {
+	start, prev := d.offset(), d.mark
+	if prev < 0 {
+		d.mark = start
+	}
+	err := d.skip()
+	d.mark = prev
 	if err != nil {
-		return 0, err
-	} else if len(buf) <= 1 {
-		return 0, errors.New("bencode: empty integer field")
+		return nil, err
+	}
+	return d.data[start-d.base : d.off], nil
+}
+
+// skip consumes the next value without decoding it.
+func (d *decodeState) skip() error {
+	c, err := d.peekValue()
+	if err != nil {
+		return err
+	}
+
+	switch c {
+	case 'i':
+		_, err := d.readIntBytes()
+		return err
+
+	case 'l', 'd':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		d.off++
+		for {
+			ok, err := d.more()
+			if err != nil || !ok {
+				return err
+			}
+			if c == 'd' {
+				if err := d.skipKey(); err != nil {
+					return err
+				}
+			}
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return d.skipString()
+	}
+}
+
+// skipKey consumes a dictionary key without decoding it.
+func (d *decodeState) skipKey() error {
+	c, err := d.peek()
+	if err != nil {
+		return err
+	}
+	if !isDigit(c) {
+		return d.syntaxError("dict key is not a string")
+	}
+	return d.skipString()
+}
+
+// skipString consumes a byte string value without decoding it.
+func (d *decodeState) skipString() error {
+	n, err := d.readStringLen()
+	if err != nil {
+		return err
+	}
+	return d.discard(int64(n))
+}
+
+// value consumes the next value and returns it as an int64, string (or
+// []byte, if useBytes is set), List or Dict.
+func (d *decodeState) value() (interface{}, error) {
+	c, err := d.peekValue()
+	if err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case 'i':
+		if d.overflow == IntOverflowBigInt {
+			return d.readIntOrBigInt()
+		}
+		return d.readInt(64, int64Type)
+
+	case 'l':
+		l, err := d.appendList(NewList())
+		return List(l), err
+
+	case 'd':
+		if d.ordered {
+			return d.readOrderedDict(nil)
+		}
+		dict := NewDict()
+		return dict, d.readDict(dict)
+
+	default:
+		if d.lazy {
+			var s LazyString
+			err := d.readLazyString(&s)
+			return s, err
+		}
+		b, err := d.readString()
+		if d.useBytes {
+			return d.bytes(b), err
+		}
+		return string(b), err
+	}
+}
+
+// appendList consumes a list and appends its elements to l.
+func (d *decodeState) appendList(l []interface{}) ([]interface{}, error) {
+	if err := d.enter(); err != nil {
+		return l, err
+	}
+	defer d.leave()
+	d.off++ // 'l'
+	for {
+		ok, err := d.more()
+		if err != nil || !ok {
+			return l, err
+		}
+
+		v, err := d.value()
+		if err != nil {
+			return l, err
+		}
+		l = append(l, v)
+	}
+}
+
+// appendStrings consumes a list of byte strings and appends them to l.
+func (d *decodeState) appendStrings(l []string) ([]string, error) {
+	d.off++ // 'l'
+	for {
+		ok, err := d.more()
+		if err != nil || !ok {
+			return l, err
+		}
+
+		c, err := d.peekValue()
+		if err != nil {
+			return l, err
+		}
+		if !isDigit(c) {
+			if err := d.mismatch(c, stringType); err != nil {
+				return l, err
+			}
+			continue
+		}
+
+		b, err := d.readString()
+		if err != nil {
+			return l, err
+		}
+		l = append(l, string(b))
+	}
+}
+
+// readOrderedDict consumes a dictionary value, appending its entries to od
+// in the order they appear.
+func (d *decodeState) readOrderedDict(od OrderedDict) (OrderedDict, error) {
+	if err := d.enter(); err != nil {
+		return od, err
+	}
+	defer d.leave()
+	d.off++ // 'd'
+	for {
+		ok, err := d.more()
+		if err != nil || !ok {
+			return od, err
+		}
+
+		key, err := d.readKey()
+		if err != nil {
+			return od, err
+		}
+		v, err := d.value()
+		if err != nil {
+			return od, err
+		}
+		od = append(od, DictEntry{Key: key, Value: v})
+	}
+}
+
+// readDict consumes a dictionary and stores its entries in m. Entries of m
+// that already hold a non-nil pointer are decoded into the value it points
+// to rather than being replaced.
+func (d *decodeState) readDict(m map[string]interface{}) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	d.off++ // 'd'
+	for {
+		ok, err := d.more()
+		if err != nil || !ok {
+			return err
+		}
+
+		key, err := d.readKey()
+		if err != nil {
+			return err
+		}
+		if d.foldKeys {
+			key = foldKey(m, key)
+		}
+
+		if p, ok := m[key]; ok && isPointer(p) {
+			err = d.decode(p)
+		} else {
+			m[key], err = d.value()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// foldKey returns the key of m that matches key case-insensitively,
+// preferring an exact match, or key itself if there is none.
+func foldKey(m map[string]interface{}, key string) string {
+	if _, ok := m[key]; ok {
+		return key
+	}
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
+
+// foldMapKey is like foldKey, for maps of any type with string keys.
+func foldMapKey(m, key reflect.Value) reflect.Value {
+	if m.MapIndex(key).IsValid() {
+		return key
+	}
+	for _, k := range m.MapKeys() {
+		if strings.EqualFold(k.String(), key.String()) {
+			return k
+		}
+	}
+	return key
+}
+
+// isPointer reports whether v holds a non-nil pointer.
+func isPointer(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && !rv.IsNil()
+}
+
+// validInt reports whether b is the text of an integer as permitted by
+// BEP 3: decimal digits with an optional minus sign, no leading zeros and no
+// negative zero.
+func validInt(b []byte) bool {
+	if len(b) > 0 && b[0] == '-' {
+		b = b[1:]
+		if len(b) > 0 && b[0] == '0' {
+			return false
+		}
+	}
+	if len(b) == 0 || (b[0] == '0' && len(b) > 1) {
+		return false
+	}
+	for _, c := range b {
+		if !isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseUint returns the value of the decimal digits b, reporting whether it
+// fits in a uint64.
+func parseUint(b []byte) (uint64, bool) {
+	var n uint64
+	for _, c := range b {
+		digit := uint64(c - '0')
+		if n > (math.MaxUint64-digit)/10 {
+			return 0, false
+		}
+		n = n*10 + digit
+	}
+	return n, true
+}
+
+// parseInt returns the value of the validated integer b, reporting whether
+// it fits in an int64.
+func parseInt(b []byte) (int64, bool) {
+	neg := b[0] == '-'
+	if neg {
+		b = b[1:]
 	}
 
-	return strconv.ParseInt(string(buf[:len(buf)-1]), 10, 64)
+	n, ok := parseUint(b)
+	switch {
+	case !ok:
+		return 0, false
+	case neg && n <= 1<<63:
+		return -int64(n), true
+	case !neg && n <= math.MaxInt64:
+		return int64(n), true
+	}
+	return 0, false
 }
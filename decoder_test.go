@@ -5,8 +5,15 @@
 package bencode
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"net/netip"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 var unmarshalTests = []struct {
@@ -27,13 +34,382 @@ var unmarshalTests = []struct {
 
 func TestUnmarshal(t *testing.T) {
 	for _, test := range unmarshalTests {
-		got, err := Unmarshal([]byte(test.input))
+		got := reflect.New(reflect.TypeOf(test.expected))
+		err := Unmarshal([]byte(test.input), got.Interface())
+		if err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(got.Elem().Interface(), test.expected) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", got.Elem().Interface(), test.expected)
+		}
+	}
+}
+
+var unmarshalTypedTests = []struct {
+	input    string
+	expected interface{}
+}{
+	{"i42e", int(42)},
+	{"i-128e", int8(-128)},
+	{"i65535e", uint16(65535)},
+	{"i18446744073709551615e", uint64(18446744073709551615)},
+	{"i-9223372036854775808e", int64(-9223372036854775808)},
+	{"0:", ""},
+	{"4:spam", []byte("spam")},
+	{"l4:spam4:eggse", []string{"spam", "eggs"}},
+	{"li1e1:ae", []interface{}{int64(1), "a"}},
+	{"d4:listl1:ai2eee", map[string]interface{}{"list": List{"a", int64(2)}}},
+	{"d1:a1:x1:b1:ye", map[string]string{"a": "x", "b": "y"}},
+	{"d8:completei5e10:incompletei2ee", map[string]int64{"complete": 5, "incomplete": 2}},
+	{"d1:ad1:bi1eee", map[string]map[string]uint16{"a": {"b": 1}}},
+	{"d1:al1:bee", map[peerID][]string{"a": {"b"}}},
+	{"de", map[string]string{}},
+	{"i1500000000e", time.Unix(1500000000, 0)},
+	{"i1e", true},
+	{"4:spam", [4]byte{'s', 'p', 'a', 'm'}},
+	{"li1ei-2ee", []int64{1, -2}},
+	{"le", []int64{}},
+	{"ll7:udp://ael7:udp://b7:udp://cee", [][]string{{"udp://a"}, {"udp://b", "udp://c"}}},
+	{"l2:ab0:e", [][]byte{[]byte("ab"), nil}},
+	{"ld6:Lengthi1e4:Pathleee", []testFile{{Length: 1, Path: []string{}}}},
+	{"li1ei2ei3ee", [2]uint16{1, 2}},
+	{"li1ee", [2]uint16{1, 0}},
+	{"8:-AZ2060-", peerID("-AZ2060-")},
+	{"i6881e", testPort(6881)},
+	{"i-1e", testOffset(-1)},
+	{"i1e", testFlag(true)},
+	{"i-8e", int8(-8)},
+	{"i1800e", 30 * time.Minute},
+	{"1:0", false},
+	{"9:192.0.2.1", netip.MustParseAddr("192.0.2.1")},
+	{"d1:a3:::1e", map[string]netip.Addr{"a": netip.IPv6Loopback()}},
+	{"20:aaaaaaaaaaaaaaaaaaaz", testHash{'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'a', 'z'}},
+}
+
+type peerID string
+
+func TestUnmarshalTyped(t *testing.T) {
+	for _, test := range unmarshalTypedTests {
+		got := reflect.New(reflect.TypeOf(test.expected))
+		err := Unmarshal([]byte(test.input), got.Interface())
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err)
+		} else if !reflect.DeepEqual(got.Elem().Interface(), test.expected) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", got.Elem().Interface(), test.expected)
+		}
+	}
+}
+
+var unmarshalErrorTests = []struct {
+	input string
+	dst   interface{}
+	err   interface{}
+}{
+	{"", new(string), io.ErrUnexpectedEOF},
+	{"i42", new(int64), io.ErrUnexpectedEOF},
+	{"5:abc", new(string), io.ErrUnexpectedEOF},
+	{"l1:a", new(List), io.ErrUnexpectedEOF},
+	{"x", new(string), &SyntaxError{}},
+	{"i-0e", new(int64), &SyntaxError{}},
+	{"i03e", new(int64), &SyntaxError{}},
+	{"ie", new(int64), &SyntaxError{}},
+	{"i4x2e", new(int64), &SyntaxError{}},
+	{"03:abc", new(string), &SyntaxError{}},
+	{"-1:a", new(string), &SyntaxError{}},
+	{"di1ei2ee", new(Dict), &SyntaxError{}},
+	{"i42e", new(string), &UnmarshalTypeError{}},
+	{"4:spam", new(int64), &UnmarshalTypeError{}},
+	{"i256e", new(uint8), &UnmarshalTypeError{}},
+	{"i1e", new(netip.Addr), &UnmarshalTypeError{}},
+	{"i2e", new(bool), &UnmarshalTypeError{}},
+	{"3:abc", new([4]byte), &UnmarshalTypeError{}},
+	{"i1e", new([4]byte), &UnmarshalTypeError{}},
+	{"li1e1:ae", new([]int64), &UnmarshalTypeError{}},
+	{"i65536e", new(testPort), &UnmarshalTypeError{}},
+	{"i1e", new(peerID), &UnmarshalTypeError{}},
+	{"4:true", new(bool), &UnmarshalTypeError{}},
+	{"le", new(testHash), &UnmarshalTypeError{}},
+	{"i-1e", new(uint64), &UnmarshalTypeError{}},
+	{"i9223372036854775808e", new(int64), &UnmarshalTypeError{}},
+	{"le", new(Dict), &UnmarshalTypeError{}},
+	{"li1ee", new([]string), &UnmarshalTypeError{}},
+	{"i1e", nil, &InvalidUnmarshalError{}},
+	{"i1e", int64(0), &InvalidUnmarshalError{}},
+	{"i1e", (*int64)(nil), &InvalidUnmarshalError{}},
+	{"de", Dict(nil), &InvalidUnmarshalError{}},
+	{"le", NewDict(), &UnmarshalTypeError{}},
+	{"i1e", new(float64), &UnmarshalTypeError{}},
+	{"d1:ai1ee", new(map[string]string), &UnmarshalTypeError{}},
+	{"d1:a1:be", new(map[int]string), &UnmarshalTypeError{}},
+	{"l1:ae", new(map[string]string), &UnmarshalTypeError{}},
+}
+
+func TestUnmarshalMapMismatch(t *testing.T) {
+	got := map[string]int64{}
+	err := Unmarshal([]byte("d1:ai1e1:b1:x1:ci3ee"), got)
+	if terr, ok := err.(*UnmarshalTypeError); !ok || terr.Value != "string" || terr.Type != int64Type {
+		t.Errorf("expected *UnmarshalTypeError for string, got %#v", err)
+	}
+	if got["a"] != 1 || got["c"] != 3 {
+		t.Errorf("expected other entries to be decoded, got %#v", got)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, test := range unmarshalErrorTests {
+		err := Unmarshal([]byte(test.input), test.dst)
+		if err == nil {
+			t.Errorf("%q: expected error", test.input)
+		} else if reflect.TypeOf(err) != reflect.TypeOf(test.err) {
+			t.Errorf("%q:\ngot:      %#v\nexpected: %#v", test.input, err, test.err)
+		} else if test.err == io.ErrUnexpectedEOF && err != test.err {
+			t.Errorf("%q:\ngot:      %#v\nexpected: %#v", test.input, err, test.err)
+		}
+	}
+}
+
+var unmarshalInterfaceTests = []struct {
+	input    string
+	expected interface{}
+}{
+	{"i42e", int64(42)},
+	{"7:example", "example"},
+	{"li1el1:aee", List{int64(1), List{"a"}}},
+	{"d1:ad1:bi1eee", Dict{"a": Dict{"b": int64(1)}}},
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	for _, test := range unmarshalInterfaceTests {
+		var got interface{}
+		err := Unmarshal([]byte(test.input), &got)
 		if err != nil {
 			t.Error(err)
 		} else if !reflect.DeepEqual(got, test.expected) {
 			t.Errorf("\ngot:      %#v\nexpected: %#v", got, test.expected)
 		}
 	}
+
+	var s string
+	var v interface{} = &s
+	if err := Unmarshal([]byte("4:spam"), &v); err != nil {
+		t.Error(err)
+	} else if v != &s || s != "spam" {
+		t.Errorf("expected decoding through pointer in interface, got %#v", v)
+	}
+}
+
+func TestUnmarshalAs(t *testing.T) {
+	s, err := UnmarshalAs[string]([]byte("4:spam"))
+	if err != nil || s != "spam" {
+		t.Errorf("got %q, %v", s, err)
+	}
+
+	dict, err := UnmarshalAs[Dict]([]byte("d1:ai1ee"))
+	if err != nil || !reflect.DeepEqual(dict, Dict{"a": int64(1)}) {
+		t.Errorf("got %#v, %v", dict, err)
+	}
+
+	if _, err := UnmarshalAs[int64]([]byte("4:spam")); err == nil {
+		t.Error("expected error decoding string as int64")
+	}
+}
+
+func TestUnmarshalStrict(t *testing.T) {
+	var n int64
+	if err := UnmarshalStrict([]byte("i42e"), &n); err != nil || n != 42 {
+		t.Errorf("got %d, %v", n, err)
+	}
+
+	err := UnmarshalStrict([]byte("i42ei43e"), &n)
+	if serr, ok := err.(*SyntaxError); !ok || serr.Offset != 4 {
+		t.Errorf("expected *SyntaxError at offset 4, got %#v", err)
+	}
+
+	if err := Unmarshal([]byte("i42ei43e"), &n); err != nil {
+		t.Errorf("expected Unmarshal to ignore trailing data, got %v", err)
+	}
+}
+
+func TestUnmarshalPrefix(t *testing.T) {
+	data := []byte("d1:ai1ee\x13BitTorrent protocol")
+
+	var dict Dict
+	n, err := UnmarshalPrefix(data, &dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 8 {
+		t.Errorf("got length %d, expected 8", n)
+	}
+	if string(data[n:]) != "\x13BitTorrent protocol" {
+		t.Errorf("unexpected remainder %q", data[n:])
+	}
+}
+
+func TestUnmarshalReader(t *testing.T) {
+	input := "d8:intervali1800e5:peers0:e"
+
+	var dict Dict
+	if err := UnmarshalReader(strings.NewReader(input+"trailing"), &dict, int64(len(input))); err != nil {
+		t.Error(err)
+	} else if expected := (Dict{"interval": int64(1800), "peers": ""}); !reflect.DeepEqual(dict, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", dict, expected)
+	}
+
+	err := UnmarshalReader(strings.NewReader(input), &dict, int64(len(input)-1))
+	if err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	r := &oneByteReader{strings.NewReader("1000000000:" + strings.Repeat("x", 10000))}
+	var s string
+	if err := UnmarshalReader(r, &s, 1024); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+
+	if err := UnmarshalReader(strings.NewReader(""), &s, 1024); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestUnmarshalSpans(t *testing.T) {
+	data := []byte("d8:announce3:url4:infod6:lengthi42e4:name4:spamee")
+
+	dict, spans, err := UnmarshalSpans(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dict["announce"] != "url" {
+		t.Errorf("unexpected announce %#v", dict["announce"])
+	}
+
+	expected := map[string]Span{"announce": {11, 16}, "info": {22, 48}}
+	if !reflect.DeepEqual(spans, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", spans, expected)
+	}
+	info := spans["info"]
+	if s := string(data[info.Start:info.End]); s != "d6:lengthi42e4:name4:spame" {
+		t.Errorf("unexpected info span %q", s)
+	}
+
+	if _, _, err := UnmarshalSpans([]byte("li1ee")); err == nil {
+		t.Error("expected error for list")
+	}
+	if _, _, err := UnmarshalSpans([]byte("d1:a")); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestUnmarshalNoCopy(t *testing.T) {
+	data := []byte("20:aaaaaaaaaaaaaaaaaaaa")
+
+	var b []byte
+	if err := UnmarshalNoCopy(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 20 || &b[0] != &data[3] {
+		t.Error("expected result to alias input")
+	}
+	if cap(b) != len(b) {
+		t.Error("expected result capacity to be limited to its length")
+	}
+
+	if err := Unmarshal(data, &b); err != nil {
+		t.Fatal(err)
+	}
+	if &b[0] == &data[3] {
+		t.Error("expected Unmarshal to copy")
+	}
+}
+
+func TestUnmarshalDict(t *testing.T) {
+	got, err := UnmarshalDict([]byte("d3:one2:aa3:two2:bbe"))
+	expected := Dict{"one": "aa", "two": "bb"}
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(got, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", got, expected)
+	}
+
+	if _, err := UnmarshalDict([]byte("le")); err == nil {
+		t.Error("expected error decoding list as Dict")
+	}
+
+	reused := Dict{"one": "old", "three": "cc"}
+	if err := Unmarshal([]byte("d3:one2:aae"), reused); err != nil {
+		t.Error(err)
+	}
+	expected = Dict{"one": "aa", "three": "cc"}
+	if !reflect.DeepEqual(reused, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", reused, expected)
+	}
+}
+
+func TestRawBytes(t *testing.T) {
+	input := "d4:infod6:lengthi42e4:name4:spame8:announce3:urle"
+
+	var raw RawBytes
+	var announce string
+	dict := Dict{"info": &raw, "announce": &announce}
+	if err := Unmarshal([]byte(input), dict); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d6:lengthi42e4:name4:spame"; string(raw) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", raw, expected)
+	}
+	if announce != "url" {
+		t.Errorf("\ngot:      %q\nexpected: %q", announce, "url")
+	}
+
+	var info Dict
+	if err := Unmarshal(raw, &info); err != nil {
+		t.Error(err)
+	} else if expected := (Dict{"length": int64(42), "name": "spam"}); !reflect.DeepEqual(info, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", info, expected)
+	}
+
+	dec := NewDecoder(&oneByteReader{strings.NewReader(input)})
+	if err := dec.Decode(&raw); err != nil {
+		t.Error(err)
+	} else if string(raw) != input {
+		t.Errorf("\ngot:      %s\nexpected: %s", raw, input)
+	}
+
+	buf, err := Marshal(Dict{"info": raw})
+	if err != nil {
+		t.Error(err)
+	} else if expected := "d4:info" + input + "e"; string(buf) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", buf, expected)
+	}
+}
+
+type upperString string
+
+func (s *upperString) UnmarshalBencode(b []byte) error {
+	var v string
+	if err := Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = upperString(strings.ToUpper(v))
+	return nil
+}
+
+func TestUnmarshaler(t *testing.T) {
+	var got upperString
+	dec := NewDecoder(&oneByteReader{strings.NewReader("7:examplei1e")})
+	if err := dec.Decode(&got); err != nil {
+		t.Error(err)
+	} else if got != "EXAMPLE" {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", got, "EXAMPLE")
+	}
+
+	var n int64
+	if err := dec.Decode(&n); err != nil || n != 1 {
+		t.Errorf("decoding after Unmarshaler: got %d, %v", n, err)
+	}
+
+	if err := Unmarshal([]byte("i42e"), &got); err == nil {
+		t.Error("expected error from UnmarshalBencode")
+	}
 }
 
 type bufferLoop struct {
@@ -49,9 +425,11 @@ func BenchmarkUnmarshalScalar(b *testing.B) {
 	d1 := NewDecoder(&bufferLoop{"7:example"})
 	d2 := NewDecoder(&bufferLoop{"i42e"})
 
+	var s string
+	var n int64
 	for i := 0; i < b.N; i++ {
-		d1.Decode()
-		d2.Decode()
+		d1.Decode(&s)
+		d2.Decode(&n)
 	}
 }
 
@@ -65,7 +443,8 @@ func TestUnmarshalLarge(t *testing.T) {
 	buf, _ := Marshal(data)
 	dec := NewDecoder(&bufferLoop{string(buf)})
 
-	got, err := dec.Decode()
+	var got Dict
+	err := dec.Decode(&got)
 	if err != nil {
 		t.Error(err)
 	} else if !reflect.DeepEqual(got, data) {
@@ -73,6 +452,76 @@ func TestUnmarshalLarge(t *testing.T) {
 	}
 }
 
+func TestUnmarshalDeep(t *testing.T) {
+	const levels = 200000
+	deep := []byte(strings.Repeat("l", levels) + strings.Repeat("e", levels))
+	expected := &SyntaxError{"exceeded max depth", DefaultMaxDepth}
+
+	var v interface{}
+	var l []interface{}
+	var r RawBytes
+	for _, target := range []interface{}{&v, &l, &r} {
+		if err := Unmarshal(deep, target); !reflect.DeepEqual(err, expected) {
+			t.Errorf("%T:\ngot:      %#v\nexpected: %#v", target, err, expected)
+		}
+	}
+	if err := NewDecoder(bytes.NewReader(deep)).Skip(); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Skip:\ngot:      %#v\nexpected: %#v", err, expected)
+	}
+	if err := NewDecoder(bytes.NewReader(deep)).Parse(&Handler{}); !reflect.DeepEqual(err, expected) {
+		t.Errorf("Parse:\ngot:      %#v\nexpected: %#v", err, expected)
+	}
+	f := NewFeed()
+	f.Write(deep)
+	if _, ok := f.Next(); ok || !reflect.DeepEqual(f.Err(), expected) {
+		t.Errorf("Feed:\ngot:      %#v\nexpected: %#v", f.Err(), expected)
+	}
+
+	// Input at the limit decodes.
+	limit := []byte(strings.Repeat("l", DefaultMaxDepth) + strings.Repeat("e", DefaultMaxDepth))
+	if err := Unmarshal(limit, &v); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecoderMaxDepth(t *testing.T) {
+	tests := []struct {
+		input string
+		read  func(dec *Decoder) error
+		err   error
+	}{
+		{"lllieeee", func(dec *Decoder) error { return dec.Skip() }, &SyntaxError{"exceeded max depth", 2}},
+		{"lli1eee", func(dec *Decoder) error { return dec.Skip() }, nil},
+		{"d1:alleee", func(dec *Decoder) error {
+			return dec.DecodeDictFunc(func(key string, dec *Decoder) error {
+				var v interface{}
+				return dec.Decode(&v)
+			})
+		}, &SyntaxError{"exceeded max depth", 5}},
+		{"llleee", func(dec *Decoder) error {
+			for {
+				if _, err := dec.Token(); err != nil {
+					return err
+				}
+			}
+		}, &SyntaxError{"exceeded max depth", 2}},
+	}
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.input), WithMaxDepth(2))
+		if err := test.read(dec); !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%q:\ngot:      %#v\nexpected: %#v", test.input, err, test.err)
+		}
+	}
+
+	// SetMaxDepth changes the limit set by the option.
+	dec := NewDecoder(strings.NewReader("llleee"), WithMaxDepth(2))
+	dec.SetMaxDepth(3)
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Errorf("decoding within the raised limit: %v", err)
+	}
+}
+
 func BenchmarkUnmarshalLarge(b *testing.B) {
 	data := map[string]interface{}{
 		"k1": []string{"a", "b", "c"},
@@ -84,6 +533,632 @@ func BenchmarkUnmarshalLarge(b *testing.B) {
 	dec := NewDecoder(&bufferLoop{string(buf)})
 
 	for i := 0; i < b.N; i++ {
-		dec.Decode()
+		var v Dict
+		dec.Decode(&v)
+	}
+}
+
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (r *oneByteReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(b[:1])
+}
+
+func TestDecoderStream(t *testing.T) {
+	for _, test := range unmarshalTests {
+		dec := NewDecoder(&oneByteReader{strings.NewReader(test.input)})
+		got := reflect.New(reflect.TypeOf(test.expected))
+		err := dec.Decode(got.Interface())
+		if err != nil {
+			t.Error(err)
+		} else if !reflect.DeepEqual(got.Elem().Interface(), test.expected) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", got.Elem().Interface(), test.expected)
+		}
+	}
+}
+
+func TestDecoderConcatenated(t *testing.T) {
+	dec := NewDecoder(&oneByteReader{strings.NewReader("i1e4:spamli42eed1:ai1ee3:end")})
+
+	var n int64
+	if err := dec.Decode(&n); err != nil || n != 1 {
+		t.Errorf("first value: got %d, %v", n, err)
+	}
+
+	var s string
+	if err := dec.Decode(&s); err != nil || s != "spam" {
+		t.Errorf("second value: got %q, %v", s, err)
+	}
+
+	// Type errors consume the mismatched value.
+	var strs []string
+	if _, ok := dec.Decode(&strs).(*UnmarshalTypeError); !ok {
+		t.Error("third value: expected *UnmarshalTypeError")
+	}
+	if err := dec.Decode(&s); err == nil {
+		t.Error("fourth value: expected error decoding dict as string")
+	}
+
+	if err := dec.Decode(&s); err != nil || s != "end" {
+		t.Errorf("fifth value: got %q, %v", s, err)
+	}
+	if err := dec.Decode(&s); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	dec = NewDecoder(strings.NewReader("i1el1:a"))
+	dec.Decode(&n)
+	var l List
+	if err := dec.Decode(&l); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestDecoderMore(t *testing.T) {
+	dec := NewDecoder(&oneByteReader{strings.NewReader("i1ei2ei3e")})
+
+	var got []int64
+	for dec.More() {
+		var n int64
+		if err := dec.Decode(&n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, n)
+	}
+	if expected := []int64{1, 2, 3}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", got, expected)
+	}
+
+	if NewDecoder(strings.NewReader("")).More() {
+		t.Error("expected More to be false on empty input")
+	}
+	if !NewDecoder(&errorReader{io.ErrClosedPipe}).More() {
+		t.Error("expected More to be true on read error")
+	}
+}
+
+func TestDecoderBuffered(t *testing.T) {
+	r := strings.NewReader("d1:ai1ee\x00\x01rest of the stream")
+	dec := NewDecoder(r)
+
+	var dict Dict
+	if err := dec.Decode(&dict); err != nil {
+		t.Fatal(err)
+	}
+
+	rest, err := io.ReadAll(io.MultiReader(dec.Buffered(), r))
+	if err != nil {
+		t.Error(err)
+	} else if string(rest) != "\x00\x01rest of the stream" {
+		t.Errorf("\ngot:      %q\nexpected: %q", rest, "\x00\x01rest of the stream")
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read(b []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestDecoderUseBytes(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d6:pieces4:\x00\x01\x02\x03e"))
+	dec.UseBytes()
+
+	var got interface{}
+	expected := Dict{"pieces": []byte{0, 1, 2, 3}}
+	if err := dec.Decode(&got); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(got, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", got, expected)
+	}
+}
+
+var intOverflowTests = []struct {
+	input    string
+	mode     IntOverflow
+	dst      interface{}
+	expected interface{}
+	err      bool
+}{
+	{"i300e", IntOverflowError, new(int8), int8(0), true},
+	{"i300e", IntOverflowSaturate, new(int8), int8(127), false},
+	{"i-300e", IntOverflowSaturate, new(int8), int8(-128), false},
+	{"i-1e", IntOverflowSaturate, new(uint32), uint32(0), false},
+	{"i99999999999999999999e", IntOverflowSaturate, new(uint64), uint64(18446744073709551615), false},
+	{"i99999999999999999999e", IntOverflowSaturate, new(int64), int64(9223372036854775807), false},
+	{"i99999999999999999999e", IntOverflowBigInt, new(int64), int64(0), true},
+	{"i42e", IntOverflowBigInt, new(interface{}), int64(42), false},
+	{"i-99999999999999999999e", IntOverflowBigInt, new(interface{}), bigInt("-99999999999999999999"), false},
+	{"i99999999999999999999e", IntOverflowError, new(big.Int), *bigInt("99999999999999999999"), false},
+}
+
+func bigInt(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	return n
+}
+
+func TestDecoderIntOverflow(t *testing.T) {
+	for _, test := range intOverflowTests {
+		dec := NewDecoder(strings.NewReader(test.input))
+		dec.SetIntOverflow(test.mode)
+
+		err := dec.Decode(test.dst)
+		got := reflect.ValueOf(test.dst).Elem().Interface()
+		if test.err != (err != nil) {
+			t.Errorf("%s (mode %d): unexpected error result %v", test.input, test.mode, err)
+		} else if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s (mode %d):\ngot:      %#v\nexpected: %#v", test.input, test.mode, got, test.expected)
+		}
+	}
+}
+
+func TestDecoderCaseInsensitiveKeys(t *testing.T) {
+	input := "d8:Intervali1800e12:Min Intervali900e5:Peers0:e"
+
+	var interval int64
+	got := Dict{"interval": &interval, "min interval": int64(0)}
+	dec := NewDecoder(strings.NewReader(input))
+	dec.CaseInsensitiveKeys()
+	if err := dec.Decode(got); err != nil {
+		t.Fatal(err)
+	}
+	expected := Dict{"interval": &interval, "min interval": int64(900), "Peers": ""}
+	if interval != 1800 || !reflect.DeepEqual(got, expected) {
+		t.Errorf("\ngot:      %#v (interval %d)\nexpected: %#v", got, interval, expected)
+	}
+
+	got = Dict{"interval": int64(0)}
+	if err := NewDecoder(strings.NewReader(input)).Decode(got); err != nil {
+		t.Fatal(err)
+	}
+	if got["interval"] != int64(0) || got["Interval"] != int64(1800) {
+		t.Errorf("expected exact key matching by default, got %#v", got)
+	}
+}
+
+func TestDecoderSkip(t *testing.T) {
+	dec := NewDecoder(&oneByteReader{strings.NewReader("d1:al1:bi1eee10:0123456789i42e")})
+
+	if err := dec.Skip(); err != nil {
+		t.Error(err)
+	}
+	if err := dec.Skip(); err != nil {
+		t.Error(err)
+	}
+	var n int64
+	if err := dec.Decode(&n); err != nil || n != 42 {
+		t.Errorf("got %d, %v", n, err)
+	}
+	if err := dec.Skip(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+
+	if err := NewDecoder(strings.NewReader("di1ei2ee")).Skip(); err == nil {
+		t.Error("expected error skipping dict with integer key")
+	}
+}
+
+func TestDecoderSkipAllocs(t *testing.T) {
+	dec := NewDecoder(&bufferLoop{"d4:infod6:lengthi42e4:name4:spame5:peersl2:ab2:cdee"})
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := dec.Skip(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per Skip, expected 0", allocs)
+	}
+}
+
+func TestDecoderDecodeDictFunc(t *testing.T) {
+	input := "d8:completei5e5:filesd20:aaaaaaaaaaaaaaaaaaaad8:completei1eee8:intervali1800e5:peersl2:abee"
+	dec := NewDecoder(&oneByteReader{strings.NewReader(input + "i7e")})
+
+	var interval, complete int64
+	var keys []string
+	err := dec.DecodeDictFunc(func(key string, dec *Decoder) error {
+		keys = append(keys, key)
+		switch key {
+		case "interval":
+			return dec.Decode(&interval)
+		case "files":
+			return dec.DecodeDictFunc(func(key string, dec *Decoder) error {
+				keys = append(keys, key)
+				return dec.DecodeDictFunc(func(key string, dec *Decoder) error {
+					keys = append(keys, key)
+					return dec.Decode(&complete)
+				})
+			})
+		case "peers":
+			return dec.Skip()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"complete", "files", "aaaaaaaaaaaaaaaaaaaa", "complete", "interval", "peers"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", keys, expected)
+	}
+	if interval != 1800 || complete != 1 {
+		t.Errorf("got interval %d, complete %d", interval, complete)
+	}
+
+	var n int64
+	if err := dec.Decode(&n); err != nil || n != 7 {
+		t.Errorf("decoding after dict: got %d, %v", n, err)
+	}
+
+	if err := dec.DecodeDictFunc(nil); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	dec = NewDecoder(strings.NewReader("le"))
+	if _, ok := dec.DecodeDictFunc(nil).(*UnmarshalTypeError); !ok {
+		t.Error("expected *UnmarshalTypeError for list")
+	}
+	dec = NewDecoder(strings.NewReader("d1:a"))
+	err = dec.DecodeDictFunc(func(key string, dec *Decoder) error {
+		return dec.Skip()
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestDecoderWalkDict(t *testing.T) {
+	input := "d5:filesld6:lengthi1eed6:lengthi2eee4:infod4:name1:x6:pieces2:abe8:intervali1800e4:skipl1:ae5:tokend1:ai1e1:bi2eee"
+	dec := NewDecoder(&oneByteReader{strings.NewReader(input + "i7e")})
+
+	var lengths []int64
+	var interval int64
+	var keys []string
+	var tokens []Token
+	err := dec.WalkDict(func(key []byte, dec *Decoder) error {
+		keys = append(keys, string(key))
+		switch string(key) {
+		case "files":
+			// The value reads as a stream of its own.
+			for v, err := range dec.Values() {
+				if err != nil {
+					return err
+				}
+				for _, f := range v.(List) {
+					lengths = append(lengths, f.(Dict)["length"].(int64))
+				}
+			}
+		case "info":
+			return dec.WalkDict(func(key []byte, dec *Decoder) error {
+				keys = append(keys, string(key))
+				return nil
+			})
+		case "interval":
+			if err := dec.Decode(&interval); err != nil {
+				return err
+			}
+			if err := dec.Skip(); err != io.EOF {
+				t.Errorf("reading past the value: got %v, expected io.EOF", err)
+			}
+		case "token":
+			// The rest of a value partly read by Token is skipped.
+			for len(tokens) < 2 {
+				tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				tokens = append(tokens, tok)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"files", "info", "name", "pieces", "interval", "skip", "token"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", keys, expected)
+	}
+	if !reflect.DeepEqual(lengths, []int64{1, 2}) || interval != 1800 {
+		t.Errorf("got lengths %v, interval %d", lengths, interval)
+	}
+	if expected := []Token{DictStart, []byte("a")}; !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", tokens, expected)
+	}
+	var n int64
+	if err := dec.Decode(&n); err != nil || n != 7 {
+		t.Errorf("decoding after dict: got %d, %v", n, err)
+	}
+
+	dec = NewDecoder(strings.NewReader("le"))
+	if _, ok := dec.WalkDict(nil).(*UnmarshalTypeError); !ok {
+		t.Error("expected *UnmarshalTypeError for list")
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("l1:a"))
+	dec.UseBytes()
+	var v interface{}
+	if err := dec.Decode(&v); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	dec.Reset(strings.NewReader("4:spam"))
+	if err := dec.Decode(&v); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(v, []byte("spam")) {
+		t.Errorf("expected configuration to be kept, got %#v", v)
+	}
+
+	r := strings.NewReader("")
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset("d8:intervali1800ee")
+		dec.Reset(r)
+		if err := dec.DecodeDictFunc(func(key string, dec *Decoder) error {
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 1 {
+		t.Errorf("got %v allocations per message, expected at most 1", allocs)
+	}
+}
+
+func TestDecoderDecodeListFunc(t *testing.T) {
+	r, w := io.Pipe()
+	dec := NewDecoder(r)
+
+	// Each element is written only once the previous one has been handled.
+	handled := make(chan bool)
+	go func() {
+		w.Write([]byte("l"))
+		for _, s := range []string{"6:\x7f\x00\x00\x01\x1a\xe1", "6:\x7f\x00\x00\x01\x1a\xe2"} {
+			w.Write([]byte(s))
+			<-handled
+		}
+		w.Write([]byte("e"))
+		w.Close()
+	}()
+
+	var peers []string
+	err := dec.DecodeListFunc(func(dec *Decoder) error {
+		var peer string
+		err := dec.Decode(&peer)
+		peers = append(peers, peer)
+		handled <- true
+		return err
+	})
+	expected := []string{"\x7f\x00\x00\x01\x1a\xe1", "\x7f\x00\x00\x01\x1a\xe2"}
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(peers, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", peers, expected)
+	}
+
+	dec = NewDecoder(strings.NewReader("li1ei2ei3eei4e"))
+	var sum int64
+	err = dec.DecodeListFunc(func(dec *Decoder) error {
+		var n int64
+		err := dec.Decode(&n)
+		sum += n
+		return err
+	})
+	if err != nil || sum != 6 {
+		t.Errorf("got sum %d, %v", sum, err)
+	}
+	if err := dec.DecodeListFunc(nil); err == nil {
+		t.Error("expected error for integer")
+	}
+}
+
+func TestDecoderList(t *testing.T) {
+	input := "ld2:ip8:10.0.0.14:porti6881eed2:ip8:10.0.0.24:porti6882eee"
+	dec := NewDecoder(&oneByteReader{strings.NewReader(input + "i7e" + "li1ei2ei3eei8e")})
+
+	type peer struct {
+		IP   string `bencode:"ip"`
+		Port int    `bencode:"port"`
+	}
+	var peers []peer
+	for dec, err := range dec.List() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p peer
+		if err := dec.Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		if err := dec.Decode(&p); err != io.EOF {
+			t.Errorf("reading past the element: got %v, expected io.EOF", err)
+		}
+		peers = append(peers, p)
+	}
+	expected := []peer{{"10.0.0.1", 6881}, {"10.0.0.2", 6882}}
+	if !reflect.DeepEqual(peers, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", peers, expected)
+	}
+
+	var n int64
+	if err := dec.Decode(&n); err != nil || n != 7 {
+		t.Errorf("decoding after list: got %d, %v", n, err)
+	}
+	// Breaking out of the loop skips the rest of the list.
+	for range dec.List() {
+		break
+	}
+	if err := dec.Decode(&n); err != nil || n != 8 {
+		t.Errorf("decoding after break: got %d, %v", n, err)
+	}
+
+	// An error skipping the rest of the list is returned by the next read.
+	dec = NewDecoder(strings.NewReader("li1ei2e"))
+	for range dec.List() {
+		break
+	}
+	if !dec.More() {
+		t.Error("More after a truncated list: got false, expected true")
+	}
+	if err := dec.Decode(&n); err != io.ErrUnexpectedEOF {
+		t.Errorf("decoding after a truncated list: got %v, expected io.ErrUnexpectedEOF", err)
+	}
+
+	for _, input := range []string{"de", "l1:a"} {
+		var errs []error
+		for _, err := range NewDecoder(strings.NewReader(input)).List() {
+			errs = append(errs, err)
+		}
+		if len(errs) == 0 || errs[len(errs)-1] == nil {
+			t.Errorf("%q: expected an error, got %v", input, errs)
+		}
+	}
+}
+
+func TestDecoderProgress(t *testing.T) {
+	input := "l1:a2:bce"
+	var reads []int64
+	dec := NewDecoder(&oneByteReader{strings.NewReader(input)}, WithProgress(func(p Progress) error {
+		if p.Offset > p.Read {
+			t.Errorf("consumed %d bytes of %d read", p.Offset, p.Read)
+		}
+		reads = append(reads, p.Read)
+		return nil
+	}))
+	var v []string
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(reads, expected) {
+		t.Errorf("\ngot:      %v\nexpected: %v", reads, expected)
+	}
+
+	// A watchdog stops the decode.
+	errStop := errors.New("stop")
+	dec = NewDecoder(&oneByteReader{strings.NewReader(input)})
+	dec.SetProgress(func(p Progress) error {
+		if p.Read > 3 {
+			return errStop
+		}
+		return nil
+	})
+	if err := dec.Decode(&v); err != errStop {
+		t.Errorf("got %v, expected %v", err, errStop)
+	}
+
+	// The depth follows the value being read, and the path the tokens.
+	type place struct {
+		depth int
+		path  string
+	}
+	var places []place
+	dec = NewDecoder(&oneByteReader{strings.NewReader("d4:infold1:ai1eeee")}, WithProgress(func(p Progress) error {
+		if pl := (place{p.Depth, p.Path}); len(places) == 0 || places[len(places)-1] != pl {
+			places = append(places, pl)
+		}
+		return nil
+	}))
+	dec.Token()
+	dec.Token()
+	var info interface{}
+	if err := dec.Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	dec.Token()
+	expected := []place{{0, ""}, {1, ""}, {1, "info"}, {2, "info"}, {3, "info"}, {2, "info"}, {1, "info"}}
+	if !reflect.DeepEqual(places, expected) {
+		t.Errorf("\ngot:      %v\nexpected: %v", places, expected)
+	}
+}
+
+func TestDecoderResumable(t *testing.T) {
+	var buf bytes.Buffer
+	dec := NewDecoder(&buf, WithResumable())
+	if dec.More() {
+		t.Error("got More before any input")
+	}
+
+	buf.WriteString("d8:intervali18")
+	var v struct {
+		Interval int      `bencode:"interval"`
+		Peers    []string `bencode:"peers"`
+	}
+	if err := dec.Decode(&v); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, expected io.ErrUnexpectedEOF", err)
+	}
+	buf.WriteString("00e5:peersl2:ab")
+	if err := dec.Skip(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, expected io.ErrUnexpectedEOF", err)
+	}
+	buf.WriteString("ee4:spam")
+	if !dec.More() {
+		t.Fatal("expected More once input has arrived")
+	}
+	v.Peers = nil
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Interval != 1800 || !reflect.DeepEqual(v.Peers, []string{"ab"}) {
+		t.Errorf("got %#v", v)
+	}
+	var s string
+	if err := dec.Decode(&s); err != nil || s != "spam" {
+		t.Errorf("got %q, %v; expected \"spam\"", s, err)
+	}
+
+	// Without Resumable, the end of the input is final.
+	buf.WriteString("d1:a")
+	dec = NewDecoder(&buf)
+	if err := dec.Skip(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, expected io.ErrUnexpectedEOF", err)
+	}
+	buf.WriteString("i1ee")
+	if err := dec.Skip(); err == nil {
+		t.Error("expected an error after the end of the input")
+	}
+}
+
+func TestDecoderValues(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i1e4:spamle"))
+
+	var got []interface{}
+	for v, err := range dec.Values() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v)
+	}
+	if expected := []interface{}{int64(1), "spam", List{}}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", got, expected)
+	}
+
+	var errs int
+	for _, err := range NewDecoder(strings.NewReader("i1ex")).Values() {
+		if err != nil {
+			errs++
+		}
+	}
+	if errs != 1 {
+		t.Errorf("expected iteration to stop after one error, got %d", errs)
+	}
+}
+
+func TestDecoderLongString(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	dec := NewDecoder(strings.NewReader("10000:" + long))
+
+	var got string
+	if err := dec.Decode(&got); err != nil {
+		t.Error(err)
+	} else if got != long {
+		t.Errorf("got string of length %d, expected %d", len(got), len(long))
 	}
 }
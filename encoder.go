@@ -5,33 +5,356 @@
 package bencode
 
 import (
+	"bufio"
 	"bytes"
+	"encoding"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
+	"reflect"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 )
 
 // An Encoder writes bencoded objects to an output stream.
 type Encoder struct {
-	w io.Writer
+	e      encodeState
+	atomic bool          // values are staged in buf before being written
+	buf    bytes.Buffer  // staging buffer, if atomic
+	stack  []streamFrame // dictionaries and lists begun and not yet ended
+	bw     *bufio.Writer // buffer in front of the stream, if buffered
+	stream io.Writer     // the stream, if buffered
 }
 
-// NewEncoder returns a new encoder that writes to w.
-func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w}
+// NewEncoder returns a new encoder that writes to w, configured by opts.
+func NewEncoder(w io.Writer, opts ...Option) *Encoder {
+	enc := &Encoder{e: encodeState{w: w}}
+	for _, opt := range opts {
+		if opt.enc != nil {
+			opt.enc(enc)
+		}
+	}
+	return enc
 }
 
-// Encode writes the bencoding of v to the stream.
+// Encode writes the bencoding of v to the stream. If a write to the stream
+// fails, Encode returns the error, and so does every later call, as the
+// stream no longer holds whole values. The same holds if v fails to encode
+// after part of it was written within a dictionary or list begun by
+// BeginDict or BeginList; if nothing of v was written, the value may be
+// given again.
 func (enc *Encoder) Encode(v interface{}) error {
-	return marshal(enc.w, v)
+	if err := enc.beginValue(); err != nil {
+		return err
+	}
+	if enc.atomic && enc.e.err == nil {
+		err := enc.encodeAtomic(v)
+		if err != nil && enc.e.err == nil {
+			enc.unbeginValue()
+		}
+		return err
+	}
+	written := enc.e.written
+	err := enc.e.marshal(v)
+	if enc.e.err != nil {
+		return enc.e.err
+	}
+	if err != nil && len(enc.stack) > 0 {
+		if enc.e.written != written {
+			enc.e.err = err
+		} else {
+			enc.unbeginValue()
+		}
+	}
+	return err
+}
+
+// encodeAtomic encodes v into the Encoder's buffer, then writes it to the
+// stream in one call if it was encoded in full.
+func (enc *Encoder) encodeAtomic(v interface{}) error {
+	enc.buf.Reset()
+	w := enc.e.w
+	enc.e.w = &enc.buf
+	err := enc.e.marshal(v)
+	enc.e.w = w
+	if err != nil {
+		return err
+	}
+	_, err = enc.e.Write(enc.buf.Bytes())
+	return err
+}
+
+// SetAtomic sets whether the Encoder stages each value in a buffer and
+// writes it to the stream only once it has been encoded in full, in a
+// single Write, so that a value that fails to encode halfway through leaves
+// nothing on the stream, as an HTTP response must not be corrupted. The
+// buffer is kept for reuse.
+func (enc *Encoder) SetAtomic(atomic bool) {
+	enc.atomic = atomic
+}
+
+// SetBuffered sets whether the Encoder buffers its output, so that the many
+// small writes of encoding a value reach the stream in few large ones, as
+// matters when writing to a net.Conn. A buffered Encoder holds back output
+// until its buffer fills or Flush is called, so Flush must be called once
+// the values have been encoded. Turning buffering off flushes the buffer;
+// an error doing so is returned by the next call to the Encoder.
+func (enc *Encoder) SetBuffered(buffered bool) {
+	switch {
+	case buffered && enc.bw == nil:
+		enc.stream = enc.e.w
+		enc.bw = bufio.NewWriter(enc.stream)
+		enc.e.w = enc.bw
+	case !buffered && enc.bw != nil:
+		enc.Flush()
+		enc.e.w, enc.bw, enc.stream = enc.stream, nil, nil
+	}
+}
+
+// Flush writes any output the Encoder has buffered to the stream. It
+// returns the first error writing to the stream, as Encode does.
+func (enc *Encoder) Flush() error {
+	if enc.e.err != nil || enc.bw == nil {
+		return enc.e.err
+	}
+	enc.e.err = enc.bw.Flush()
+	return enc.e.err
+}
+
+// EncodeAll writes the bencodings of vs to the stream back to back, as in a
+// pipeline of KRPC messages on one connection. It stops at the first value
+// that cannot be encoded.
+func (enc *Encoder) EncodeAll(vs ...interface{}) error {
+	for _, v := range vs {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetKeyFunc makes the Encoder derive the keys of struct fields without a
+// name in their tag by calling fn with the field's name, so that a naming
+// convention need not be spelled out in every tag. Passing nil restores the
+// default of using the name unchanged.
+func (enc *Encoder) SetKeyFunc(fn func(fieldName string) string) {
+	enc.e.keys = newKeyFunc(fn)
 }
 
 // Marshal returns the bencoding of v.
+//
+// Strings and byte slices are encoded as byte strings, and integers of any
+// size as integers, including those held by a big.Int or *big.Int. Values
+// of defined types, such as a PeerID string or a Port uint16, are encoded
+// according to their underlying type.
+//
+// Slices and arrays are encoded as lists of their elements, except that
+// those of bytes, such as a [20]byte infohash, are encoded as byte strings.
+// Maps whose keys are of string kind, including Dicts and types such as
+// map[FileKey]FileInfo, are encoded as dictionaries with their keys in
+// sorted order, so that equal maps always have the same encoding; an
+// OrderedDict keeps the order of its entries instead. Lists and
+// dictionaries may be nested in any combination, as in the generic values
+// Unmarshal produces: each element is encoded by the same rules, whatever
+// its type.
+//
+// A bool is encoded as the integer 0 or 1, as for the "private" flag of a
+// .torrent file, unless an Encoder is set to encode byte strings instead;
+// see SetBoolStrings. A time.Time is encoded as an integer of seconds since
+// the Unix epoch, as for the "creation date" of a .torrent file; see
+// SetTimeUnit for other precisions.
+// Floating-point values have no bencoding and are reported as a
+// *FloatValueError, unless an Encoder is set to encode them otherwise; see
+// SetFloatPolicy.
+//
+// Values of other types implementing encoding.TextMarshaler, such as net.IP
+// and netip.Addr, are encoded as a byte string of their text form; see
+// SetCompactIPs for the binary form of addresses. Failing that, values
+// implementing encoding.BinaryMarshaler are encoded as a byte string of
+// their binary form.
+//
+// A RawBytes is written verbatim, as are the results of the MarshalBencode
+// method of values implementing Marshaler, so that a value that is already
+// encoded, such as the info dictionary of a torrent, can be embedded in
+// another.
+//
+// Pointers are encoded as the value they point to. Dictionary entries
+// holding a nil pointer or interface, from struct fields or maps, are left
+// out, as an Encoder does by default; see SetNilPolicy.
+//
+// Structs are encoded as dictionaries with an entry for each exported
+// field. The entry's key is the field's name, unless the field has a tag
+// giving another, as in:
+//
+//	AnnounceList [][]string `bencode:"announce-list"`
+//
+// An Encoder can be given a function to derive the keys of fields whose tag
+// has no name; see SetKeyFunc. A field tagged "-" is never encoded or
+// decoded. A field whose key is "-" can be tagged "-," instead.
+//
+// The tag's name may be followed by a comma-separated list of options. The
+// "omitempty" option leaves out the entry if the field has an empty value:
+// zero, an empty string, slice or map, a nil pointer or interface, or the
+// zero time.
+//
+//	WarningMessage string `bencode:"warning message,omitempty"`
+//
+// The "string" option on a field of bool or integer type encodes it as a
+// byte string of its decimal form instead, such as "1" or "6881"; when
+// decoding, such fields accept either form. The "unixmilli" option on a
+// field of type time.Time encodes milliseconds instead of seconds.
+//
+//	Port         uint16    `bencode:"port,string"`
+//	CreationDate time.Time `bencode:"creation date,omitempty"`
+//
+// The "required" and "default=" options and the validation options have no
+// effect on encoding; see Unmarshal.
+//
+// The fields of an untagged embedded struct, or pointer to one, are encoded
+// as if they were in the outer struct, following the rules of encoding/json
+// when several fields share a key.
+//
+// The "inline" option promotes the fields of a struct field in the same
+// way. On a map field with string keys, such as a Dict, it instead merges
+// the map's entries into the dictionary; when decoding, entries without a
+// corresponding field are stored in that map.
+//
+//	Extensions Dict `bencode:",inline"`
+//
+// The "rest" option is a synonym for "inline" on map fields, marking a
+// catch-all for entries a struct does not otherwise declare. With a
+// map[string]RawBytes, those entries are kept in their original encoding,
+// so that they survive decoding, modifying and re-encoding the struct.
+//
+//	Unknown map[string]RawBytes `bencode:",rest"`
 func Marshal(v interface{}) ([]byte, error) {
-	buf := &bytes.Buffer{}
-	err := marshal(buf, v)
-	return buf.Bytes(), err
+	buf := newBuffer()
+	err := buf.e.marshal(v)
+	b := append([]byte(nil), buf.w.b...)
+	buf.Release()
+	return b, err
+}
+
+// MarshalTo writes the bencoding of v to w in a single Write, so that a
+// response is never written in part. Nothing is written if v cannot be
+// encoded.
+func MarshalTo(w io.Writer, v interface{}) error {
+	buf, err := MarshalBuffer(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	buf.Release()
+	return err
+}
+
+// A Buffer holds the bencoding of a value returned by MarshalBuffer, in
+// memory that is reused once the Buffer is released.
+type Buffer struct {
+	w appendWriter
+	e encodeState
+}
+
+var bufferPool = sync.Pool{New: func() interface{} { return new(Buffer) }}
+
+// maxPooledBuffer is the capacity above which a released Buffer is left to
+// the garbage collector rather than pooled, so that one large encoding does
+// not pin its memory for good.
+const maxPooledBuffer = 64 << 10
+
+func newBuffer() *Buffer {
+	buf := bufferPool.Get().(*Buffer)
+	buf.w.b = buf.w.b[:0]
+	buf.e = encodeState{w: &buf.w}
+	return buf
+}
+
+// MarshalBuffer is like Marshal, but returns the encoding in a Buffer drawn
+// from a pool, so that a server encoding a response per request need not
+// allocate a new one each time. The Buffer should be released once its
+// contents have been used:
+//
+//	buf, err := bencode.MarshalBuffer(resp)
+//	if err != nil {
+//		return err
+//	}
+//	defer buf.Release()
+//	_, err = w.Write(buf.Bytes())
+func MarshalBuffer(v interface{}) (*Buffer, error) {
+	buf := newBuffer()
+	if err := buf.e.marshal(v); err != nil {
+		buf.Release()
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Bytes returns the encoding held by b. The slice is only valid until b is
+// released.
+func (b *Buffer) Bytes() []byte {
+	return b.w.b
+}
+
+// Release returns b to the pool. Neither b nor the slice returned by Bytes
+// may be used afterwards.
+func (b *Buffer) Release() {
+	if cap(b.w.b) > maxPooledBuffer {
+		return
+	}
+	b.e = encodeState{}
+	bufferPool.Put(b)
+}
+
+// MarshalAppend appends the bencoding of v to dst and returns the extended
+// slice, so that a caller can reuse one buffer across many values. If v
+// cannot be encoded, dst is returned unextended along with the error.
+func MarshalAppend(dst []byte, v interface{}) ([]byte, error) {
+	// The encodeState of a pooled Buffer is borrowed, appending to dst in
+	// place of the Buffer's own slice, so that neither escapes anew.
+	buf := bufferPool.Get().(*Buffer)
+	own := buf.w.b
+	buf.w.b = dst
+	buf.e = encodeState{w: &buf.w}
+	err := buf.e.marshal(v)
+	out := buf.w.b
+	buf.w.b, buf.e = own, encodeState{}
+	bufferPool.Put(buf)
+	if err != nil {
+		return dst, err
+	}
+	return out, nil
+}
+
+// An appendWriter appends what is written to it to b.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
+
+func (w *appendWriter) WriteString(s string) (int, error) {
+	w.b = append(w.b, s...)
+	return len(s), nil
+}
+
+// EncodedLen returns the length of the bencoding of v, as Marshal would
+// return it, without keeping the encoding, so that a server can set a
+// Content-Length header or size a buffer before encoding v.
+func EncodedLen(v interface{}) (int, error) {
+	e := encodeState{w: io.Discard}
+	if err := e.marshal(v); err != nil {
+		return 0, err
+	}
+	return int(e.written), nil
 }
 
 // Marshaler is the interface implemented by objects that can marshal
@@ -40,124 +363,801 @@ type Marshaler interface {
 	MarshalBencode() ([]byte, error)
 }
 
+// SetNilPolicy sets how the Encoder handles nil pointers and interfaces
+// held by struct fields, map entries and lists.
+func (enc *Encoder) SetNilPolicy(policy NilPolicy) {
+	enc.e.nilPolicy = policy
+}
+
+// SetSortKeys sets whether the Encoder writes the keys of maps, including
+// Dicts, in sorted order, as BEP 3 requires. Keys are sorted by default;
+// turning sorting off saves its cost where the output need not be valid
+// bencoding, as in benchmarks. The keys of structs are always sorted.
+func (enc *Encoder) SetSortKeys(sort bool) {
+	enc.e.unsorted = !sort
+}
+
+// SetBoolStrings makes the Encoder encode bools as the byte strings t and f,
+// such as "yes" and "no", for peers that expect them instead of the integers
+// 1 and 0, which are written by default. Struct fields with the "string"
+// option are still encoded as "1" or "0".
+func (enc *Encoder) SetBoolStrings(t, f string) {
+	enc.e.boolTrue = []byte(strconv.Itoa(len(t)) + ":" + t)
+	enc.e.boolFalse = []byte(strconv.Itoa(len(f)) + ":" + f)
+}
+
+// SetCompactIPs sets whether the Encoder encodes net.IP, netip.Addr and
+// netip.AddrPort values in their compact binary form, as in the peer lists
+// of BEP 23: a byte string of the 4 or 16 bytes of the address, followed by
+// the 2 bytes of the port in network byte order. IPv4-mapped IPv6 addresses
+// are encoded as IPv4 addresses. By default, these values are encoded as a
+// byte string of their text form, such as "192.0.2.1" or "[::1]:6881".
+func (enc *Encoder) SetCompactIPs(compact bool) {
+	enc.e.compactIPs = compact
+}
+
+// SetTimeUnit sets the unit of the integers the Encoder encodes time.Time
+// values as, such as time.Millisecond; times are rounded down to a whole
+// number of units since the Unix epoch. The default is time.Second. Struct
+// fields with the "unixmilli" option are still encoded in milliseconds.
+func (enc *Encoder) SetTimeUnit(unit time.Duration) {
+	enc.e.timeUnit = unit
+}
+
+// SetFloatPolicy sets how the Encoder handles floating-point values, which
+// BEP 3 does not define an encoding for. By default they are reported as a
+// *FloatValueError.
+func (enc *Encoder) SetFloatPolicy(policy FloatPolicy) {
+	enc.e.floatPolicy = policy
+}
+
+// SetFloatScale sets the factor by which the FloatScaled policy multiplies
+// floating-point values before rounding them to integers. The default is 1.
+func (enc *Encoder) SetFloatScale(scale float64) {
+	enc.e.floatScale = scale
+}
+
+// A FloatPolicy selects how an Encoder handles floating-point values.
+type FloatPolicy int
+
+const (
+	// FloatError reports a *FloatValueError. This is the default.
+	FloatError FloatPolicy = iota
+
+	// FloatString encodes a byte string of the value's shortest decimal
+	// form, such as "0.5" or "1e+21", which strconv.ParseFloat reads back
+	// exactly.
+	FloatString
+
+	// FloatScaled encodes an integer of the value multiplied by the scale
+	// set with SetFloatScale and rounded to the nearest integer, so that a
+	// ratio of 0.25 with a scale of 1000 is encoded as i250e. Values that
+	// are not finite, or too large for an int64 after scaling, are reported
+	// as a *FloatValueError.
+	FloatScaled
+)
+
+// A FloatValueError describes a floating-point value that the Encoder could
+// not encode under its FloatPolicy.
+type FloatValueError struct {
+	Value float64
+}
+
+func (e *FloatValueError) Error() string {
+	return "bencode: unsupported float value " + strconv.FormatFloat(e.Value, 'g', -1, 64)
+}
+
+// A NilPolicy selects how an Encoder handles nil values, which have no
+// bencoding.
+type NilPolicy int
+
+const (
+	// NilOmit leaves out the dictionary entry holding the value. This is
+	// the default. Nil list elements cannot be left out and are reported
+	// as an error.
+	NilOmit NilPolicy = iota
+
+	// NilError reports a *NilValueError for dictionary entries holding the
+	// value.
+	NilError
+
+	// NilEmpty encodes the zero value of a nil pointer's element type, such
+	// as an empty byte string for a *string, and an empty byte string for a
+	// nil interface.
+	NilEmpty
+)
+
+// A NilValueError describes a nil value that the Encoder was configured
+// not to leave out.
+type NilValueError struct {
+	Type reflect.Type // type of the struct or map containing the value
+	Key  string       // key of the value's entry
+}
+
+func (e *NilValueError) Error() string {
+	return "bencode: nil value for key " + strconv.Quote(e.Key) + " in " + e.Type.String()
+}
+
+// encodeState holds the output and configuration of an encoding in
+// progress.
+type encodeState struct {
+	w         io.Writer
+	err       error     // first error writing to w
+	written   int64     // number of bytes written to w
+	keys      *keyFunc  // mapping of untagged struct field names, or nil
+	nilPolicy NilPolicy // handling of nil values
+	unsorted  bool      // map keys are written in iteration order
+
+	boolTrue    []byte        // encoding of true, or nil for i1e
+	boolFalse   []byte        // encoding of false, if boolTrue is set
+	compactIPs  bool          // IP addresses are written in binary form
+	timeUnit    time.Duration // unit of encoded times, or 0 for seconds
+	floatPolicy FloatPolicy   // handling of floating-point values
+	floatScale  float64       // multiplier of scaled floats, or 0 for 1
+
+	hook EncodeHook // transformation of values, or nil
+	path []byte     // path of the value being encoded, if hook is set
+
+	scratch [64]byte // room to format an integer or a short string in
+}
+
+// Write writes p to the output. Once a write fails, or writes less than
+// all of p, the error is kept and later writes are discarded, so that the
+// writes of an encoding need not be checked one by one.
+func (e *encodeState) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+	n, err := e.w.Write(p)
+	return n, e.wrote(n, len(p), err)
+}
+
+// writeString writes s to the output as Write does, using its WriteString
+// method if it has one.
+func (e *encodeState) writeString(s string) {
+	if e.err != nil {
+		return
+	}
+	sw, ok := e.w.(io.StringWriter)
+	if !ok {
+		e.Write([]byte(s))
+		return
+	}
+	n, err := sw.WriteString(s)
+	e.wrote(n, len(s), err)
+}
+
+// wrote records the outcome of writing n of size bytes to the output.
+func (e *encodeState) wrote(n, size int, err error) error {
+	e.written += int64(n)
+	if err == nil && n < size {
+		err = io.ErrShortWrite
+	}
+	e.err = err
+	return err
+}
+
 // marshal writes types bencoded to an io.Writer
-func marshal(w io.Writer, data interface{}) error {
+func (e *encodeState) marshal(data interface{}) error {
+	if e.hook != nil {
+		return e.marshalHooked(data)
+	}
+	return e.marshalValue(data)
+}
+
+// marshalValue writes data, without passing it to the encode hook.
+func (e *encodeState) marshalValue(data interface{}) error {
 	switch v := data.(type) {
+	case RawBytes:
+		if len(v) == 0 {
+			return errEmptyRawBytes
+		}
+		_, err := e.Write(v)
+		if err != nil {
+			return err
+		}
+
 	case Marshaler:
+		if isNil(reflect.ValueOf(v)) {
+			return e.marshalReflect(data)
+		}
 		bencoded, err := v.MarshalBencode()
 		if err != nil {
 			return err
 		}
-		_, err = w.Write(bencoded)
+		_, err = e.Write(bencoded)
 		if err != nil {
 			return err
 		}
 
 	case string:
-		marshalString(w, v)
+		e.marshalString(v)
 
 	case int:
-		marshalInt(w, int64(v))
+		e.marshalInt(int64(v))
 
 	case uint:
-		marshalUint(w, uint64(v))
+		e.marshalUint(uint64(v))
+
+	case int8:
+		e.marshalInt(int64(v))
+
+	case uint8:
+		e.marshalUint(uint64(v))
 
 	case int16:
-		marshalInt(w, int64(v))
+		e.marshalInt(int64(v))
 
 	case uint16:
-		marshalUint(w, uint64(v))
+		e.marshalUint(uint64(v))
 
 	case int32:
-		marshalInt(w, int64(v))
+		e.marshalInt(int64(v))
 
 	case uint32:
-		marshalUint(w, uint64(v))
+		e.marshalUint(uint64(v))
 
 	case int64:
-		marshalInt(w, v)
+		e.marshalInt(v)
 
 	case uint64:
-		marshalUint(w, v)
+		e.marshalUint(v)
+
+	case uintptr:
+		e.marshalUint(uint64(v))
+
+	case float64:
+		return e.marshalFloat(v, 64)
+
+	case float32:
+		return e.marshalFloat(float64(v), 32)
 
 	case []byte:
-		marshalBytes(w, v)
+		e.marshalBytes(v)
+
+	case *big.Int:
+		if v == nil {
+			return e.marshalReflect(data)
+		}
+		e.marshalBigInt(v)
+
+	case big.Int:
+		e.marshalBigInt(&v)
 
 	case time.Duration: // Assume seconds
-		marshalInt(w, int64(v/time.Second))
+		e.marshalInt(int64(v / time.Second))
+
+	case time.Time:
+		e.marshalTime(v)
+
+	case bool:
+		e.marshalBool(v)
+
+	case net.IP:
+		if !e.compactIPs {
+			return e.marshalReflect(data)
+		}
+		if ip4 := v.To4(); ip4 != nil {
+			v = ip4
+		}
+		e.marshalBytes(v)
+
+	case netip.Addr:
+		if !e.compactIPs {
+			return e.marshalReflect(data)
+		}
+		e.marshalBytes(v.Unmap().AsSlice())
+
+	case netip.AddrPort:
+		if !e.compactIPs {
+			return e.marshalReflect(data)
+		}
+		b := v.Addr().Unmap().AsSlice()
+		e.marshalBytes(binary.BigEndian.AppendUint16(b, v.Port()))
 
 	case Dict:
-		marshal(w, map[string]interface{}(v))
+		return e.marshalValue(map[string]interface{}(v))
 
-	case []Dict:
-		w.Write([]byte{'l'})
-		for _, val := range v {
-			err := marshal(w, val)
-			if err != nil {
+	case OrderedDict:
+		e.Write([]byte{'d'})
+		for _, entry := range v {
+			if entry.Value == nil || isNil(reflect.ValueOf(entry.Value)) {
+				omit, err := e.omitNil(reflect.TypeOf(v), entry.Key)
+				if err != nil {
+					return err
+				}
+				if omit {
+					continue
+				}
+			}
+			e.marshalString(entry.Key)
+			if err := e.marshalAt(entry.Key, entry.Value); err != nil {
 				return err
 			}
 		}
-		w.Write([]byte{'e'})
+		e.Write([]byte{'e'})
 
-	case map[string]interface{}:
-		w.Write([]byte{'d'})
-		for key, val := range v {
-			marshalString(w, key)
-			err := marshal(w, val)
+	case []Dict:
+		e.Write([]byte{'l'})
+		for _, val := range v {
+			err := e.marshal(val)
 			if err != nil {
 				return err
 			}
 		}
-		w.Write([]byte{'e'})
+		e.Write([]byte{'e'})
+
+	case map[string]interface{}:
+		return marshalDict(e, v, e.marshal, func(val interface{}) bool {
+			return val == nil || isNil(reflect.ValueOf(val))
+		})
+
+	case map[string]string:
+		return marshalDict(e, v, func(val string) error {
+			e.marshalString(val)
+			return nil
+		}, nil)
+
+	case map[string]int64:
+		return marshalDict(e, v, func(val int64) error {
+			e.marshalInt(val)
+			return nil
+		}, nil)
 
 	case []string:
-		w.Write([]byte{'l'})
+		e.Write([]byte{'l'})
 		for _, val := range v {
-			err := marshal(w, val)
+			err := e.marshal(val)
 			if err != nil {
 				return err
 			}
 		}
-		w.Write([]byte{'e'})
+		e.Write([]byte{'e'})
+
+	case [][]byte:
+		e.Write([]byte{'l'})
+		for _, val := range v {
+			e.marshalBytes(val)
+		}
+		e.Write([]byte{'e'})
+
+	case []int:
+		e.Write([]byte{'l'})
+		for _, val := range v {
+			e.marshalInt(int64(val))
+		}
+		e.Write([]byte{'e'})
+
+	case []int64:
+		e.Write([]byte{'l'})
+		for _, val := range v {
+			e.marshalInt(val)
+		}
+		e.Write([]byte{'e'})
+
+	case []uint64:
+		e.Write([]byte{'l'})
+		for _, val := range v {
+			e.marshalUint(val)
+		}
+		e.Write([]byte{'e'})
 
 	case List:
-		marshal(w, []interface{}(v))
+		return e.marshalValue([]interface{}(v))
 
 	case []interface{}:
-		w.Write([]byte{'l'})
+		e.Write([]byte{'l'})
 		for _, val := range v {
-			err := marshal(w, val)
+			err := e.marshal(val)
 			if err != nil {
 				return err
 			}
 		}
-		w.Write([]byte{'e'})
+		e.Write([]byte{'e'})
 
 	default:
-		return fmt.Errorf("attempted to marshal unsupported type:\n%t", v)
+		return e.marshalReflect(v)
+	}
+
+	return nil
+}
+
+// marshalReflect writes the types that marshal does not know statically.
+// Only the type switch above is on the path of the types it handles, so
+// reflection costs nothing unless it is needed.
+func (e *encodeState) marshalReflect(data interface{}) error {
+	if m, ok := data.(encoding.TextMarshaler); ok && !isNil(reflect.ValueOf(m)) {
+		b, err := m.MarshalText()
+		if err != nil {
+			return err
+		}
+		e.marshalBytes(b)
+		return nil
+	}
+	if m, ok := data.(encoding.BinaryMarshaler); ok && !isNil(reflect.ValueOf(m)) {
+		b, err := m.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.marshalBytes(b)
+		return nil
+	}
+
+	v := reflect.ValueOf(data)
+	switch v.Kind() {
+	case reflect.Invalid:
+		if e.nilPolicy == NilEmpty {
+			e.marshalString("")
+			return nil
+		}
+
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return e.marshalValue(v.Elem().Interface())
+		}
+		if e.nilPolicy == NilEmpty {
+			return e.marshalValue(reflect.New(v.Type().Elem()).Interface())
+		}
+
+	case reflect.String:
+		e.marshalString(v.String())
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.marshalInt(v.Int())
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.marshalUint(v.Uint())
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		return e.marshalFloat(v.Float(), v.Type().Bits())
+
+	case reflect.Bool:
+		e.marshalBool(v.Bool())
+		return nil
+
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.marshalBytes(b)
+			return nil
+		}
+		return e.marshalList(v)
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.marshalBytes(v.Bytes())
+			return nil
+		}
+		return e.marshalList(v)
+
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			return e.marshalMap(v)
+		}
+
+	case reflect.Struct:
+		return e.marshalStruct(v)
+	}
+
+	return fmt.Errorf("attempted to marshal unsupported type:\n%T", data)
+}
+
+// marshalTime writes t as an integer of the Encoder's time unit since the
+// Unix epoch.
+func (e *encodeState) marshalTime(t time.Time) {
+	switch unit := e.timeUnit; {
+	case unit == 0 || unit == time.Second:
+		e.marshalInt(t.Unix())
+	case unit == time.Millisecond:
+		e.marshalInt(t.UnixMilli())
+	case unit%time.Second == 0:
+		e.marshalInt(floorDiv(t.Unix(), int64(unit/time.Second)))
+	default:
+		e.marshalInt(floorDiv(t.UnixNano(), int64(unit)))
+	}
+}
+
+// floorDiv returns a/b rounded down.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// marshalFloat writes f, a floating-point value of the given bit size,
+// according to the Encoder's FloatPolicy.
+func (e *encodeState) marshalFloat(f float64, bits int) error {
+	switch e.floatPolicy {
+	case FloatString:
+		e.marshalString(strconv.FormatFloat(f, 'g', -1, bits))
+		return nil
+
+	case FloatScaled:
+		scale := e.floatScale
+		if scale == 0 {
+			scale = 1
+		}
+		r := math.Round(f * scale)
+		if r >= -(1<<63) && r < 1<<63 {
+			e.marshalInt(int64(r))
+			return nil
+		}
+	}
+	return &FloatValueError{Value: f}
+}
+
+// An EncodeHook is called by an Encoder with each value it is about to
+// encode, and returns the value to encode in its place, or an error to stop
+// encoding. path locates the value within the one passed to Encode, in the
+// form ExtractPath reads: dot-separated dictionary keys and list indices,
+// such as "peers.0.peer id", or "" for the value itself.
+type EncodeHook func(path string, v interface{}) (interface{}, error)
+
+// SetEncodeHook makes the Encoder pass each value it encodes through hook,
+// so that fields such as peer IDs can be redacted, rewritten or audited in
+// one place. Dictionaries and lists are passed to hook before their
+// contents, which are then passed in turn. Values implementing Marshaler,
+// encoding.TextMarshaler or encoding.BinaryMarshaler, and OrderedDicts, are
+// passed whole. Struct fields with the "string" or "unixmilli" option are
+// not passed to hook. Setting the hook to nil removes it.
+func (enc *Encoder) SetEncodeHook(hook EncodeHook) {
+	enc.e.hook = hook
+}
+
+// marshalHooked writes the value the encode hook returns for data. Lists,
+// maps and structs are walked by reflection rather than the fast paths of
+// marshalValue, which do not track the path of each element.
+func (e *encodeState) marshalHooked(data interface{}) error {
+	v, err := e.hook(string(e.path), data)
+	if err != nil {
+		return err
 	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() && !isOpaque(v) {
+		rv = rv.Elem()
+		v = rv.Interface()
+	}
+	if !isOpaque(v) {
+		switch rv.Kind() {
+		case reflect.Array, reflect.Slice:
+			if rv.Type().Elem().Kind() != reflect.Uint8 {
+				return e.marshalList(rv)
+			}
+		case reflect.Map:
+			if rv.Type().Key().Kind() == reflect.String {
+				return e.marshalMap(rv)
+			}
+		case reflect.Struct:
+			return e.marshalStruct(rv)
+		}
+	}
+
+	return e.marshalValue(v)
+}
+
+// isOpaque reports whether v is encoded whole rather than as a container
+// of other values.
+func isOpaque(v interface{}) bool {
+	switch v.(type) {
+	case Marshaler, encoding.TextMarshaler, encoding.BinaryMarshaler, big.Int, OrderedDict:
+		return true
+	}
+	return false
+}
+
+// marshalAt writes v as the value of the dictionary entry with the given
+// key, extending the path passed to the encode hook.
+func (e *encodeState) marshalAt(key string, v interface{}) error {
+	if e.hook == nil {
+		return e.marshal(v)
+	}
+	n := len(e.path)
+	if n > 0 {
+		e.path = append(e.path, '.')
+	}
+	e.path = append(e.path, key...)
+	err := e.marshal(v)
+	e.path = e.path[:n]
+	return err
+}
+
+// marshalIndex writes v as the list element with index i, extending the
+// path passed to the encode hook.
+func (e *encodeState) marshalIndex(i int, v interface{}) error {
+	if e.hook == nil {
+		return e.marshal(v)
+	}
+	return e.marshalAt(strconv.Itoa(i), v)
+}
 
+// marshalDict writes m as a dictionary, calling marshalValue to write each
+// value, with its keys in sorted order unless sorting is turned off. If
+// nilValue is not nil, it reports the values handled by the NilPolicy.
+func marshalDict[V any](e *encodeState, m map[string]V, marshalValue func(V) error, nilValue func(V) bool) error {
+	entry := func(key string, val V) error {
+		if nilValue != nil && nilValue(val) {
+			if omit, err := e.omitNil(reflect.TypeOf(m), key); omit || err != nil {
+				return err
+			}
+		}
+		e.marshalString(key)
+		return marshalValue(val)
+	}
+
+	e.Write([]byte{'d'})
+	if e.unsorted {
+		for key, val := range m {
+			if err := entry(key, val); err != nil {
+				return err
+			}
+		}
+	} else {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := entry(key, m[key]); err != nil {
+				return err
+			}
+		}
+	}
+	e.Write([]byte{'e'})
 	return nil
 }
 
-func marshalInt(w io.Writer, v int64) {
-	w.Write([]byte{'i'})
-	w.Write([]byte(strconv.FormatInt(v, 10)))
-	w.Write([]byte{'e'})
+// omitNil reports whether the entry for key, holding a nil value in a
+// dictionary encoded from a value of type t, is left out under the
+// Encoder's NilPolicy, or the error the policy reports for it.
+func (e *encodeState) omitNil(t reflect.Type, key string) (bool, error) {
+	switch e.nilPolicy {
+	case NilEmpty:
+		return false, nil
+	case NilError:
+		return false, &NilValueError{Type: t, Key: key}
+	}
+	return true, nil
 }
 
-func marshalUint(w io.Writer, v uint64) {
-	w.Write([]byte{'i'})
-	w.Write([]byte(strconv.FormatUint(v, 10)))
-	w.Write([]byte{'e'})
+// marshalList writes l, a slice or array, as a list of its elements.
+func (e *encodeState) marshalList(l reflect.Value) error {
+	e.Write([]byte{'l'})
+	for i := 0; i < l.Len(); i++ {
+		if err := e.marshalIndex(i, l.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	e.Write([]byte{'e'})
+	return nil
 }
 
-func marshalBytes(w io.Writer, v []byte) {
-	w.Write([]byte(strconv.Itoa(len(v))))
-	w.Write([]byte{':'})
-	w.Write(v)
+// marshalMap writes m, a map with keys of string kind, as a dictionary with
+// its keys in sorted order unless sorting is turned off.
+func (e *encodeState) marshalMap(m reflect.Value) error {
+	e.Write([]byte{'d'})
+	keys := m.MapKeys()
+	if !e.unsorted {
+		sortMapKeys(keys)
+	}
+	for _, k := range keys {
+		val := m.MapIndex(k)
+		if isNilEntry(val) {
+			omit, err := e.omitNil(m.Type(), k.String())
+			if err != nil {
+				return err
+			}
+			if omit {
+				continue
+			}
+		}
+		e.marshalString(k.String())
+		if err := e.marshalAt(k.String(), val.Interface()); err != nil {
+			return err
+		}
+	}
+	e.Write([]byte{'e'})
+	return nil
+}
+
+// marshalBool writes v as the integer 0 or 1, or as one of the byte strings
+// set with SetBoolStrings.
+func (e *encodeState) marshalBool(v bool) {
+	switch {
+	case e.boolTrue == nil && v:
+		e.Write([]byte("i1e"))
+	case e.boolTrue == nil:
+		e.Write([]byte("i0e"))
+	case v:
+		e.Write(e.boolTrue)
+	default:
+		e.Write(e.boolFalse)
+	}
 }
 
-func marshalString(w io.Writer, v string) {
-	marshalBytes(w, []byte(v))
+// smallIntCount is the number of small non-negative integers, such as
+// intervals, ports and flags, whose encodings are kept ready in a table.
+const smallIntCount = 10000
+
+var (
+	smallIntsOnce sync.Once
+	smallIntData  []byte                    // encodings of 0 to smallIntCount-1
+	smallIntEnds  [smallIntCount + 1]uint16 // offsets of the encodings in smallIntData
+)
+
+// smallInt returns the encoding of v, which must be less than
+// smallIntCount, building the table on first use.
+func smallInt(v int) []byte {
+	smallIntsOnce.Do(func() {
+		smallIntData = make([]byte, 0, 58890) // the length of all the encodings
+		for i := 0; i < smallIntCount; i++ {
+			smallIntData = appendInt(smallIntData, int64(i))
+			smallIntEnds[i+1] = uint16(len(smallIntData))
+		}
+	})
+	return smallIntData[smallIntEnds[v]:smallIntEnds[v+1]:smallIntEnds[v+1]]
+}
+
+// marshalInt writes the integer v, formatted in e's scratch buffer so that
+// nothing is allocated, or taken from the table of small integers.
+func (e *encodeState) marshalInt(v int64) {
+	if v >= 0 && v < smallIntCount {
+		e.Write(smallInt(int(v)))
+		return
+	}
+	b := append(e.scratch[:0], 'i')
+	b = strconv.AppendInt(b, v, 10)
+	e.Write(append(b, 'e'))
+}
+
+// marshalUint writes the integer v, as marshalInt does.
+func (e *encodeState) marshalUint(v uint64) {
+	if v < smallIntCount {
+		e.Write(smallInt(int(v)))
+		return
+	}
+	b := append(e.scratch[:0], 'i')
+	b = strconv.AppendUint(b, v, 10)
+	e.Write(append(b, 'e'))
+}
+
+// marshalBigInt writes the integer v, as marshalInt does, in one call; a
+// value too long for e's scratch buffer is formatted in a larger one.
+func (e *encodeState) marshalBigInt(v *big.Int) {
+	b := append(e.scratch[:0], 'i')
+	b = v.Append(b, 10)
+	e.Write(append(b, 'e'))
+}
+
+// marshalBytes writes the byte string v. Its length is formatted in e's
+// scratch buffer, along with v itself if it fits, so that a short string is
+// written in one call.
+func (e *encodeState) marshalBytes(v []byte) {
+	b := strconv.AppendInt(e.scratch[:0], int64(len(v)), 10)
+	b = append(b, ':')
+	if len(v) <= cap(b)-len(b) {
+		e.Write(append(b, v...))
+		return
+	}
+	e.Write(b)
+	e.Write(v)
+}
+
+// marshalString writes the byte string v as marshalBytes does, without
+// converting v to a []byte unless the output lacks a WriteString method.
+func (e *encodeState) marshalString(v string) {
+	b := strconv.AppendInt(e.scratch[:0], int64(len(v)), 10)
+	b = append(b, ':')
+	if len(v) <= cap(b)-len(b) {
+		e.Write(append(b, v...))
+		return
+	}
+	e.Write(b)
+	e.writeString(v)
 }
@@ -6,6 +6,15 @@ package bencode
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -21,17 +30,93 @@ var marshalTests = []struct {
 	{uint64(45), "i45e"},
 	{int16(44), "i44e"},
 	{uint16(45), "i45e"},
+	{int8(-128), "i-128e"},
+	{uint8(255), "i255e"},
+	{int32(-2147483648), "i-2147483648e"},
+	{uint32(4294967295), "i4294967295e"},
+	{int64(-9223372036854775808), "i-9223372036854775808e"},
+	{uintptr(46), "i46e"},
+	{bigInt("-99999999999999999999"), "i-99999999999999999999e"},
+	{*bigInt("18446744073709551616"), "i18446744073709551616e"},
+	{big.NewInt(0), "i0e"},
 
 	{"example", "7:example"},
 	{[]byte("example"), "7:example"},
+	{[]byte{}, "0:"},
+	{[]byte("-AZ2060-\x00\xff\x10e:li"), "15:-AZ2060-\x00\xff\x10e:li"},
+	{testBytes("e"), "1:e"},
+	{[4]byte{'s', 'p', 'a', 'm'}, "4:spam"},
+	{[0]byte{}, "0:"},
 	{30 * time.Minute, "i1800e"},
+	{time.Unix(1500000000, 0), "i1500000000e"},
+	{true, "i1e"},
+	{false, "i0e"},
+	{netip.MustParseAddr("192.0.2.1"), "9:192.0.2.1"},
+	{netip.MustParseAddrPort("[::1]:6881"), "10:[::1]:6881"},
+	{testHash{'a', 19: 'z'}, "20:a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00z"},
 
 	{[]string{"one", "two"}, "l3:one3:twoe"},
 	{[]interface{}{"one", "two"}, "l3:one3:twoe"},
+	{[]interface{}{int64(1), "a", []interface{}{}, Dict{"k": List{}}}, "li1e1:aled1:kleee"},
 	{[]string{}, "le"},
+	{[]int64{1, -2}, "li1ei-2ee"},
+	{[]int{16384, 0}, "li16384ei0ee"},
+	{[]uint64{18446744073709551615}, "li18446744073709551615ee"},
+	{[][]string{{"udp://a"}, {"udp://b", "udp://c"}}, "ll7:udp://ael7:udp://b7:udp://cee"},
+	{[][]byte{[]byte("ab"), nil}, "l2:ab0:e"},
+	{[][]byte{}, "le"},
+	{[]testFile{{Length: 1}}, "ld6:Lengthi1e4:Pathleee"},
+	{[2]uint16{1, 2}, "li1ei2ee"},
+	{[]int(nil), "le"},
 
 	{map[string]interface{}{"one": "aa", "two": "bb"}, "d3:one2:aa3:two2:bbe"},
 	{map[string]interface{}{}, "de"},
+	{Dict{"b": "x", "a": List{int64(1)}, "aa": Dict{"z": "", "y": ""}}, "d1:ali1ee2:aad1:y0:1:z0:e1:b1:xe"},
+	{peerID("-AZ2060-"), "8:-AZ2060-"},
+	{testPort(6881), "i6881e"},
+	{testOffset(-1), "i-1e"},
+	{testFlag(true), "i1e"},
+	{int8(-8), "i-8e"},
+	{uint8(8), "i8e"},
+	{map[string]int64{"b": 2, "a": 1, "c": 3}, "d1:ai1e1:bi2e1:ci3ee"},
+	{map[string]int64{}, "de"},
+	{map[string]string{"downloaded": "5", "complete": "10"}, "d8:complete2:1010:downloaded1:5e"},
+	{map[fileKey]testFile{"z": {Length: 1}, "y": {Path: []string{"p"}}}, "d1:yd6:Lengthi0e4:Pathl1:pee1:zd6:Lengthi1e4:Pathleee"},
+	{map[string]map[string]string{"x": {"k": "v"}}, "d1:xd1:k1:vee"},
+
+	{Dict{"files": []interface{}{Dict{"length": 1, "path": []string{"a"}}}}, "d5:filesld6:lengthi1e4:pathl1:aeeee"},
+	{[]map[string]interface{}{{"a": List{[]int{1}, map[string][]byte{"b": nil}}}}, "ld1:alli1eed1:b0:eeee"},
+	{map[string][]interface{}{"x": {[][]string{{"y"}}, testPort(1)}}, "d1:xlll1:yeei1eee"},
+	{List{[2][]int64{{1}, nil}}, "llli1eeleee"},
+
+	{RawBytes("d4:name1:ae"), "d4:name1:ae"},
+	{Dict{"info": RawBytes("d4:name1:ae"), "raw": []byte("d4:name1:ae")}, "d4:infod4:name1:ae3:raw11:d4:name1:aee"},
+	{[]RawBytes{RawBytes("i1e"), RawBytes("le")}, "li1elee"},
+}
+
+type fileKey string
+
+type testBytes []byte
+
+type testPort uint16
+
+type testOffset int32
+
+type testFlag bool
+
+// testHash is a digest implementing the binary marshaling interfaces.
+type testHash [20]byte
+
+func (h testHash) MarshalBinary() ([]byte, error) {
+	return h[:], nil
+}
+
+func (h *testHash) UnmarshalBinary(b []byte) error {
+	if len(b) != len(h) {
+		return errors.New("bad hash length")
+	}
+	copy(h[:], b)
+	return nil
 }
 
 func TestMarshal(t *testing.T) {
@@ -45,6 +130,678 @@ func TestMarshal(t *testing.T) {
 	}
 }
 
+func TestMarshalEmptyRawBytes(t *testing.T) {
+	for _, v := range []interface{}{RawBytes{}, RawBytes(nil), List{RawBytes{}}, Dict{"info": RawBytes(nil)}} {
+		if _, err := Marshal(v); err != errEmptyRawBytes {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", err, errEmptyRawBytes)
+		}
+	}
+}
+
+func TestMarshalUnsupportedType(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{make(chan int), "attempted to marshal unsupported type:\nchan int"},
+		{List{1, func() {}}, "attempted to marshal unsupported type:\nfunc()"},
+		{map[int]string{1: "a"}, "attempted to marshal unsupported type:\nmap[int]string"},
+	}
+	for _, test := range tests {
+		_, err := Marshal(test.input)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("\ngot:      %v\nexpected: %s", err, test.expected)
+		}
+	}
+}
+
+type testBigInts struct {
+	Left     *big.Int `bencode:"left"`
+	Total    big.Int  `bencode:"total"`
+	Uploaded *big.Int `bencode:"uploaded"`
+}
+
+func TestMarshalBigIntRoundTrip(t *testing.T) {
+	v := testBigInts{Left: bigInt("123456789012345678901234567890")}
+	v.Total.SetInt64(-5)
+	expected := "d4:lefti123456789012345678901234567890e5:totali-5ee"
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	var decoded testBigInts
+	if err := Unmarshal(got, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Left.Cmp(v.Left) != 0 || decoded.Total.Cmp(&v.Total) != 0 || decoded.Uploaded != nil {
+		t.Errorf("\ngot:      %v\nexpected: %v", decoded, v)
+	}
+}
+
+func TestEncoderBoolStrings(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetBoolStrings("yes", "")
+	v := Dict{
+		"private": true,
+		"seed":    testFlag(false),
+		"flags":   []bool{false, true},
+		"info": struct {
+			Private bool `bencode:"private,string"`
+		}{true},
+	}
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	expected := "d5:flagsl0:3:yese4:infod7:private1:1e7:private3:yes4:seed0:e"
+	if buf.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), expected)
+	}
+}
+
+func TestEncoderTimeUnit(t *testing.T) {
+	tm := time.Unix(1500000000, 123456789)
+	tests := []struct {
+		unit     time.Duration
+		input    interface{}
+		expected string
+	}{
+		{0, tm, "i1500000000e"},
+		{time.Second, tm, "i1500000000e"},
+		{time.Millisecond, tm, "i1500000000123e"},
+		{time.Microsecond, tm, "i1500000000123456e"},
+		{time.Nanosecond, tm, "i1500000000123456789e"},
+		{time.Hour, tm, "i416666e"},
+		{time.Millisecond, time.Unix(-1, 999000000), "i-1e"},
+		{time.Minute, time.Unix(-1, 0), "i-1e"},
+		{time.Millisecond, Dict{"creation date": tm}, "d13:creation datei1500000000123ee"},
+		{time.Millisecond, struct {
+			Date time.Time `bencode:"date,unixmilli"`
+			Seen time.Time `bencode:"seen"`
+		}{tm, tm}, "d4:datei1500000000123e4:seeni1500000000123ee"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetTimeUnit(test.unit)
+		if err := enc.Encode(test.input); err != nil {
+			t.Error(err)
+		} else if buf.String() != test.expected {
+			t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), test.expected)
+		}
+	}
+}
+
+func TestEncoderCompactIPs(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		text     string
+		expected string
+	}{
+		{net.ParseIP("192.0.2.1"), "9:192.0.2.1", "4:\xc0\x00\x02\x01"},
+		{net.IP(nil), "0:", "0:"},
+		{net.ParseIP("2001:db8::1"), "11:2001:db8::1", "16: \x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01"},
+		{netip.MustParseAddr("::ffff:192.0.2.1"), "16:::ffff:192.0.2.1", "4:\xc0\x00\x02\x01"},
+		{netip.MustParseAddrPort("192.0.2.1:6881"), "14:192.0.2.1:6881", "6:\xc0\x00\x02\x01\x1a\xe1"},
+		{Dict{"ip": netip.MustParseAddr("10.0.0.1")}, "d2:ip8:10.0.0.1e", "d2:ip4:\x0a\x00\x00\x01e"},
+	}
+	for _, test := range tests {
+		for _, compact := range []bool{false, true} {
+			var buf bytes.Buffer
+			enc := NewEncoder(&buf)
+			enc.SetCompactIPs(compact)
+			expected := test.text
+			if compact {
+				expected = test.expected
+			}
+			if err := enc.Encode(test.input); err != nil {
+				t.Error(err)
+			} else if buf.String() != expected {
+				t.Errorf("\ngot:      %q\nexpected: %q", buf.String(), expected)
+			}
+		}
+	}
+}
+
+type testNilMap map[string]interface{}
+
+type testNilEntries struct {
+	Comment *string     `bencode:"comment"`
+	Info    interface{} `bencode:"info"`
+	Rest    Dict        `bencode:",inline"`
+}
+
+// testMarshaler encodes itself as the integer n, through a pointer.
+type testMarshaler struct {
+	n int
+}
+
+func (m *testMarshaler) MarshalBencode() ([]byte, error) {
+	return []byte("i" + strconv.Itoa(m.n) + "e"), nil
+}
+
+func TestEncoderNilPolicy(t *testing.T) {
+	tests := []struct {
+		policy   NilPolicy
+		input    interface{}
+		expected string
+		err      error
+	}{
+		{NilOmit, Dict{"a": nil, "b": (*int)(nil), "c": "x"}, "d1:c1:xe", nil},
+		{NilOmit, map[string]*testFile{"a": nil, "b": {}}, "d1:bd6:Lengthi0e4:Pathleee", nil},
+		{NilOmit, testNilEntries{Rest: Dict{"x": nil}}, "de", nil},
+		{NilOmit, testNilEntries{Rest: Dict{"x": (*int)(nil)}}, "de", nil},
+		{NilOmit, testNilMap{"a": (*int)(nil), "b": 1}, "d1:bi1ee", nil},
+		{NilOmit, testNilEntries{Info: (*int)(nil)}, "de", nil},
+		{NilOmit, List{nil}, "", nil},
+		{NilOmit, []*testMarshaler{nil}, "", nil},
+		{NilOmit, List{(*testMarshaler)(nil)}, "", nil},
+		{NilOmit, Dict{"a": (*testMarshaler)(nil), "b": &testMarshaler{2}}, "d1:bi2ee", nil},
+		{NilEmpty, Dict{"a": nil, "b": (*int)(nil), "c": (*testFile)(nil)}, "d1:a0:1:bi0e1:cd6:Lengthi0e4:Pathleee", nil},
+		{NilEmpty, []*string{nil}, "l0:e", nil},
+		{NilEmpty, List{(*testMarshaler)(nil)}, "li0ee", nil},
+		{NilEmpty, testNilEntries{Rest: Dict{"x": nil}}, "d7:comment0:4:info0:1:x0:e", nil},
+		{NilEmpty, testNilEntries{Rest: Dict{"x": (*int)(nil)}}, "d7:comment0:4:info0:1:xi0ee", nil},
+		{NilEmpty, testNilMap{"a": (*int)(nil), "b": 1}, "d1:ai0e1:bi1ee", nil},
+		{NilEmpty, testNilEntries{Info: (*int)(nil)}, "d7:comment0:4:infoi0ee", nil},
+		{NilError, Dict{"b": 1, "a": nil}, "", &NilValueError{Type: reflect.TypeOf(map[string]interface{}{}), Key: "a"}},
+		{NilError, map[fileKey]*int{"k": nil}, "", &NilValueError{Type: reflect.TypeOf(map[fileKey]*int{}), Key: "k"}},
+		{NilError, testNilEntries{Comment: new(string), Info: 1, Rest: Dict{"x": nil}}, "", &NilValueError{Type: reflect.TypeOf(testNilEntries{}), Key: "x"}},
+		{NilError, testNilEntries{Comment: new(string), Info: (*int)(nil)}, "", &NilValueError{Type: reflect.TypeOf(testNilEntries{}), Key: "info"}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetNilPolicy(test.policy)
+		err := enc.Encode(test.input)
+		if test.expected == "" {
+			if err == nil || test.err != nil && !reflect.DeepEqual(err, test.err) {
+				t.Errorf("\ngot:      %#v\nexpected: %#v", err, test.err)
+			}
+		} else if err != nil {
+			t.Error(err)
+		} else if buf.String() != test.expected {
+			t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), test.expected)
+		}
+	}
+}
+
+type testRatio float32
+
+var floatPolicyTests = []struct {
+	policy   FloatPolicy
+	scale    float64
+	input    interface{}
+	expected string
+}{
+	{FloatString, 0, 0.5, "3:0.5"},
+	{FloatString, 0, float32(0.1), "3:0.1"},
+	{FloatString, 0, 1e21, "5:1e+21"},
+	{FloatString, 0, -2.0, "2:-2"},
+	{FloatString, 0, Dict{"ratio": testRatio(1.25)}, "d5:ratio4:1.25e"},
+	{FloatScaled, 0, 2.5, "i3e"},
+	{FloatScaled, 1000, 0.25, "i250e"},
+	{FloatScaled, 100, -0.125, "i-13e"},
+	{FloatScaled, 1000, []float64{1, 0.0015}, "li1000ei2ee"},
+	{FloatScaled, 10, struct{ Ratio testRatio }{0.5}, "d5:Ratioi5ee"},
+}
+
+func TestEncoderFloatPolicy(t *testing.T) {
+	for _, test := range floatPolicyTests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetFloatPolicy(test.policy)
+		enc.SetFloatScale(test.scale)
+		if err := enc.Encode(test.input); err != nil {
+			t.Error(err)
+		} else if buf.String() != test.expected {
+			t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), test.expected)
+		}
+	}
+}
+
+func TestEncoderFloatError(t *testing.T) {
+	tests := []struct {
+		policy FloatPolicy
+		input  float64
+	}{
+		{FloatError, 1.5},
+		{FloatScaled, math.Inf(1)},
+		{FloatScaled, math.NaN()},
+		{FloatScaled, 1e19},
+	}
+	for _, test := range tests {
+		enc := NewEncoder(io.Discard)
+		enc.SetFloatPolicy(test.policy)
+		err := enc.Encode(test.input)
+		if fe, ok := err.(*FloatValueError); !ok || !(fe.Value == test.input || math.IsNaN(test.input)) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", err, &FloatValueError{Value: test.input})
+		}
+	}
+}
+
+// writeCounter counts the calls to its Write method.
+type writeCounter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestMarshalTo(t *testing.T) {
+	var w writeCounter
+	if err := MarshalTo(&w, Dict{"interval": 1800, "peers": []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d8:intervali1800e5:peersl1:a1:bee"; w.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", w.String(), expected)
+	}
+	if w.writes != 1 {
+		t.Errorf("\ngot:      %d writes\nexpected: 1 write", w.writes)
+	}
+
+	w = writeCounter{}
+	if err := MarshalTo(&w, List{"a", 1.5}); err == nil {
+		t.Error("expected error for unsupported value")
+	}
+	if w.writes != 0 {
+		t.Errorf("\ngot:      %d writes\nexpected: 0 writes", w.writes)
+	}
+}
+
+func TestMarshalBuffer(t *testing.T) {
+	for _, test := range marshalTests {
+		buf, err := MarshalBuffer(test.input)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(buf.Bytes()) != test.expected {
+			t.Errorf("\ngot:      %s\nexpected: %s", buf.Bytes(), test.expected)
+		}
+		buf.Release()
+	}
+
+	if buf, err := MarshalBuffer(List{"a", 1.5}); err == nil || buf != nil {
+		t.Errorf("got %v, %v; expected an error for unsupported value", buf, err)
+	}
+
+	var v interface{} = int64(1800)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, err := MarshalBuffer(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Release()
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per MarshalBuffer, expected 0", allocs)
+	}
+}
+
+func TestMarshalAppend(t *testing.T) {
+	buf := make([]byte, 0, 64)
+	for _, test := range marshalTests {
+		got, err := MarshalAppend(append(buf[:0], "prefix"...), test.input)
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != "prefix"+test.expected {
+			t.Errorf("\ngot:      %s\nexpected: prefix%s", got, test.expected)
+		}
+	}
+
+	got, err := MarshalAppend([]byte("x"), List{"a", 1.5})
+	if err == nil || string(got) != "x" {
+		t.Errorf("got %q, %v; expected \"x\" and an error", got, err)
+	}
+}
+
+func TestMarshalAppendAllocs(t *testing.T) {
+	values := []interface{}{int64(6881), "announce", map[string]int64{"complete": 10, "downloaded": 50, "incomplete": 3}}
+	buf := make([]byte, 0, 256)
+	for _, v := range values {
+		appendAllocs := testing.AllocsPerRun(100, func() {
+			if _, err := MarshalAppend(buf[:0], v); err != nil {
+				t.Fatal(err)
+			}
+		})
+		marshalAllocs := testing.AllocsPerRun(100, func() {
+			if _, err := Marshal(v); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if appendAllocs >= marshalAllocs {
+			t.Errorf("%#v: got %v allocations per MarshalAppend, expected fewer than Marshal's %v", v, appendAllocs, marshalAllocs)
+		}
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		MarshalAppend(buf[:0], values[0])
+		MarshalAppend(buf[:0], values[1])
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per MarshalAppend of a scalar, expected 0", allocs)
+	}
+}
+
+func TestEncodedLen(t *testing.T) {
+	for _, test := range marshalTests {
+		n, err := EncodedLen(test.input)
+		if err != nil {
+			t.Error(err)
+		} else if n != len(test.expected) {
+			t.Errorf("\ngot:      %d\nexpected: %d", n, len(test.expected))
+		}
+	}
+	if n, err := EncodedLen(List{"a", 1.5}); err == nil || n != 0 {
+		t.Errorf("got %d, %v; expected 0 and an error", n, err)
+	}
+}
+
+func TestMarshalGenericRoundTrip(t *testing.T) {
+	data := "li1e4:spamld1:ai-1eeldeeee"
+	var v interface{}
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, data)
+	}
+}
+
+func TestMarshalSmallInts(t *testing.T) {
+	for _, v := range []int64{0, 1, 9, 10, 99, 100, 1800, 6881, 9999, 10000, 65535, -1, -9999} {
+		expected := "i" + strconv.FormatInt(v, 10) + "e"
+		inputs := []interface{}{v, int(v)}
+		if v >= 0 {
+			inputs = append(inputs, uint64(v), uint16(v))
+		}
+		for _, input := range inputs {
+			got, err := Marshal(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != expected {
+				t.Errorf("%#v:\ngot:      %s\nexpected: %s", input, got, expected)
+			}
+		}
+	}
+}
+
+func TestEncoderIntAllocs(t *testing.T) {
+	enc := NewEncoder(io.Discard)
+	values := []interface{}{int64(-1800), int64(1) << 40, uint64(1) << 63, 6881, uint16(6881)}
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per Encode, expected 0", allocs)
+	}
+}
+
+func TestEncoderStringAllocs(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	values := []interface{}{"", "announce", long, []byte("peer id"), []byte(long)}
+	var buf bytes.Buffer
+	buf.Grow(1 << 10)
+	enc := NewEncoder(&buf)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per Encode, expected 0", allocs)
+	}
+
+	// A writer without a WriteString method gets the same output, with a
+	// short string and its length written at once.
+	var w writeCounter
+	enc = NewEncoder(struct{ io.Writer }{&w})
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.String() != buf.String() || w.writes != 7 {
+		t.Errorf("\ngot:      %q, %d writes\nexpected: %q, 7 writes", w.String(), w.writes, buf.String())
+	}
+}
+
+// limitWriter accepts n bytes, failing the write that would exceed them.
+// If short is set, it writes what fits without an error instead.
+type limitWriter struct {
+	n     int
+	short bool
+}
+
+var errWriteLimit = errors.New("write limit reached")
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.n {
+		w.n -= len(p)
+		return len(p), nil
+	}
+	n := w.n
+	w.n = 0
+	if w.short {
+		return n, nil
+	}
+	return n, errWriteLimit
+}
+
+func TestEncoderBuffered(t *testing.T) {
+	var w writeCounter
+	enc := NewEncoder(&w, WithBuffered(true))
+	for i := 0; i < 3; i++ {
+		if err := enc.Encode(Dict{"interval": 1800, "peers": []string{"a", "b"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.writes != 0 {
+		t.Errorf("got %d writes before Flush, expected 0", w.writes)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := strings.Repeat("d8:intervali1800e5:peersl1:a1:bee", 3); w.String() != expected || w.writes != 1 {
+		t.Errorf("\ngot:      %s (%d writes)\nexpected: %s (1 write)", w.String(), w.writes, expected)
+	}
+
+	enc.Encode("spam")
+	enc.SetBuffered(false)
+	if expected := "4:spam"; !strings.HasSuffix(w.String(), expected) || w.writes != 2 {
+		t.Errorf("got %s (%d writes), expected %s flushed", w.String(), w.writes, expected)
+	}
+	enc.Encode(1)
+	enc.Encode(2)
+	if w.writes != 4 {
+		t.Errorf("got %d writes unbuffered, expected 4", w.writes)
+	}
+
+	// A write error is reported by Flush, and by later calls.
+	enc = NewEncoder(&limitWriter{n: 4}, WithBuffered(true))
+	if err := enc.Encode("spam"); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Flush(); err != errWriteLimit {
+		t.Errorf("got %v from Flush, expected %v", err, errWriteLimit)
+	}
+	if err := enc.Encode(1); err != errWriteLimit {
+		t.Errorf("got %v after Flush, expected %v", err, errWriteLimit)
+	}
+}
+
+func TestEncoderWriteError(t *testing.T) {
+	v := Dict{"peers": List{Dict{"ip": "10.0.0.1", "port": 6881}}, "interval": 1800}
+	for _, short := range []bool{false, true} {
+		expected := errWriteLimit
+		if short {
+			expected = io.ErrShortWrite
+		}
+		for n := 0; n < 40; n++ {
+			enc := NewEncoder(&limitWriter{n: n, short: short})
+			if err := enc.Encode(v); err != expected {
+				t.Errorf("\ngot:      %#v\nexpected: %#v", err, expected)
+			}
+			if err := enc.Encode(1); err != expected {
+				t.Errorf("\ngot:      %#v\nexpected: %#v", err, expected)
+			}
+		}
+	}
+}
+
+func TestEncoderAtomic(t *testing.T) {
+	var w writeCounter
+	enc := NewEncoder(&w, WithAtomic(true))
+	if err := enc.Encode(Dict{"a": "x", "b": List{1, 1.5}}); err == nil {
+		t.Error("expected error for unsupported value")
+	}
+	if w.writes != 0 {
+		t.Errorf("\ngot:      %d writes\nexpected: 0 writes", w.writes)
+	}
+	if err := enc.EncodeAll(Dict{"a": "x", "b": List{1}}, "y"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d1:a1:x1:bli1eee1:y"; w.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", w.String(), expected)
+	}
+	if w.writes != 2 {
+		t.Errorf("\ngot:      %d writes\nexpected: 2 writes", w.writes)
+	}
+
+	enc = NewEncoder(&limitWriter{n: 3}, WithAtomic(true))
+	for i := 0; i < 2; i++ {
+		if err := enc.Encode("abc"); err != errWriteLimit {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", err, errWriteLimit)
+		}
+	}
+}
+
+type testHookPeer struct {
+	ID   string `bencode:"peer id"`
+	Port uint16 `bencode:"port"`
+}
+
+func TestEncoderHook(t *testing.T) {
+	v := Dict{
+		"interval": 1800,
+		"peers":    []testHookPeer{{"secret-a", 1}, {"secret-b", 2}},
+		"tiers":    List{[]string{"udp://a"}},
+		"raw":      RawBytes("i1e"),
+		"created":  time.Unix(1, 0),
+	}
+	var paths []string
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, WithEncodeHook(func(path string, v interface{}) (interface{}, error) {
+		paths = append(paths, path)
+		if strings.HasSuffix(path, ".peer id") {
+			return "redacted", nil
+		}
+		if path == "interval" {
+			return v.(int) * 2, nil
+		}
+		return v, nil
+	}))
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	expected := "d7:createdi1e8:intervali3600e5:peersld7:peer id8:redacted4:porti1eed7:peer id8:redacted4:porti2eee3:rawi1e5:tiersll7:udp://aeee"
+	if buf.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), expected)
+	}
+	expectedPaths := []string{"", "created", "interval", "peers", "peers.0", "peers.0.peer id", "peers.0.port", "peers.1", "peers.1.peer id", "peers.1.port", "raw", "tiers", "tiers.0", "tiers.0.0"}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", paths, expectedPaths)
+	}
+
+	errDenied := errors.New("denied")
+	enc = NewEncoder(io.Discard, WithEncodeHook(func(path string, v interface{}) (interface{}, error) {
+		if path == "a.1" {
+			return nil, errDenied
+		}
+		return v, nil
+	}))
+	if err := enc.Encode(Dict{"a": []int{1, 2}}); err != errDenied {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", err, errDenied)
+	}
+
+	// An identity hook leaves the output as it is without a hook, nil
+	// entries included.
+	identity := func(path string, v interface{}) (interface{}, error) { return v, nil }
+	for _, policy := range []NilPolicy{NilOmit, NilEmpty} {
+		v := Dict{"a": (*int)(nil), "b": 1}
+		var plain, hooked bytes.Buffer
+		if err := NewEncoder(&plain, WithNilPolicy(policy)).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewEncoder(&hooked, WithNilPolicy(policy), WithEncodeHook(identity)).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		if hooked.String() != plain.String() {
+			t.Errorf("policy %d:\ngot:      %s\nexpected: %s", policy, hooked.String(), plain.String())
+		}
+	}
+}
+
+func TestEncoderEncodeAll(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.EncodeAll(Dict{"t": "aa", "y": "q"}, int64(1), "x"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d1:t2:aa1:y1:qei1e1:x"; buf.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	if err := enc.EncodeAll("a", 1.5, "b"); err == nil {
+		t.Error("expected error for unsupported value")
+	}
+	if expected := "1:a"; buf.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), expected)
+	}
+}
+
+func TestEncoderSortKeys(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetSortKeys(false)
+	d := Dict{"b": "x", "a": "y"}
+	if err := enc.Encode(d); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "d1:a1:y1:b1:xe" && got != "d1:b1:x1:a1:ye" {
+		t.Errorf("unexpected encoding %s", got)
+	}
+
+	buf.Reset()
+	enc.SetSortKeys(true)
+	if err := enc.Encode(d); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d1:a1:y1:b1:xe"; buf.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), expected)
+	}
+}
+
 func BenchmarkMarshalScalar(b *testing.B) {
 	buf := &bytes.Buffer{}
 	encoder := NewEncoder(buf)
@@ -55,6 +812,40 @@ func BenchmarkMarshalScalar(b *testing.B) {
 	}
 }
 
+func BenchmarkMarshalIntSlice(b *testing.B) {
+	data := []int64{16384, 32768, 65536, 131072}
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+
+	for i := 0; i < b.N; i++ {
+		encoder.Encode(data)
+	}
+}
+
+func BenchmarkMarshalPieceHashes(b *testing.B) {
+	data := make([][]byte, 64)
+	for i := range data {
+		data[i] = make([]byte, 20)
+	}
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		encoder.Encode(data)
+	}
+}
+
+func BenchmarkMarshalScrape(b *testing.B) {
+	data := map[string]int64{"complete": 10, "downloaded": 50, "incomplete": 3}
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+
+	for i := 0; i < b.N; i++ {
+		encoder.Encode(data)
+	}
+}
+
 func BenchmarkMarshalLarge(b *testing.B) {
 	data := map[string]interface{}{
 		"k1": []string{"a", "b", "c"},
@@ -70,3 +861,29 @@ func BenchmarkMarshalLarge(b *testing.B) {
 		encoder.Encode(data)
 	}
 }
+
+func BenchmarkMarshalLargeUnsorted(b *testing.B) {
+	data := map[string]interface{}{
+		"k1": []string{"a", "b", "c"},
+		"k2": 42,
+		"k3": "val",
+		"k4": uint(42),
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := NewEncoder(buf)
+	encoder.SetSortKeys(false)
+
+	for i := 0; i < b.N; i++ {
+		encoder.Encode(data)
+	}
+}
+
+func BenchmarkMarshalAppendScrape(b *testing.B) {
+	data := map[string]int64{"complete": 10, "downloaded": 50, "incomplete": 3}
+	buf := make([]byte, 0, 64)
+
+	for i := 0; i < b.N; i++ {
+		buf, _ = MarshalAppend(buf[:0], data)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+// A Feed decodes a stream of bencoded values from input written to it in
+// chunks, as it arrives, for servers driven by an event loop that cannot
+// block in Decode:
+//
+//	feed.Write(chunk)
+//	for {
+//		v, ok := feed.Next()
+//		if !ok {
+//			break
+//		}
+//		handle(v)
+//	}
+//	if err := feed.Err(); err != nil {
+//		...
+//	}
+//
+// Input is held only until the values it holds have been returned.
+type Feed struct {
+	dec  Decoder // configuration, and the state values are decoded with
+	buf  []byte  // input written and not yet discarded
+	off  int     // number of bytes of buf consumed
+	base int64   // number of bytes discarded before buf
+	scan scanner // progress through the value at buf[off:]
+	err  error   // first error in the input
+}
+
+// NewFeed returns an empty Feed, which decodes values as a Decoder
+// configured by opts would.
+func NewFeed(opts ...Option) *Feed {
+	f := &Feed{}
+	f.dec.d.mark = -1
+	for _, opt := range opts {
+		if opt.dec != nil {
+			opt.dec(&f.dec)
+		}
+	}
+	return f
+}
+
+// Write appends p to the input. It always consumes all of p and returns a
+// nil error, implementing io.Writer.
+func (f *Feed) Write(p []byte) (int, error) {
+	if f.off > 0 && f.off >= len(f.buf)/2 {
+		f.buf = f.buf[:copy(f.buf, f.buf[f.off:])]
+		f.base += int64(f.off)
+		f.off = 0
+	}
+	f.buf = append(f.buf, p...)
+	return len(p), nil
+}
+
+// Next returns the next value in the input, decoded as if into an
+// interface{}, and true; or false if no value is complete yet. Once the
+// input has been found to be malformed, Next returns false and Err returns
+// the error.
+func (f *Feed) Next() (interface{}, bool) {
+	if f.err != nil || f.off == len(f.buf) {
+		return nil, false
+	}
+	var n int
+	var status scanStatus
+	f.scan, n, status = f.scan.scan(f.buf[f.off:])
+	switch status {
+	case scanShort:
+		return nil, false
+	case scanInvalid:
+		f.err = &SyntaxError{"invalid bencoded value", f.base + int64(f.off+n)}
+		return nil, false
+	}
+
+	d := &f.dec.d
+	d.data, d.off, d.base = f.buf[:f.off+n], f.off, f.base
+	var v interface{}
+	err := d.unmarshal(&v)
+	d.data = nil
+	if err != nil {
+		f.err = err
+		return nil, false
+	}
+	f.off += n
+	f.scan.reset()
+	return v, true
+}
+
+// Err returns the error that stopped the Feed, if any.
+func (f *Feed) Err() error {
+	return f.err
+}
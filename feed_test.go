@@ -0,0 +1,85 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFeed(t *testing.T) {
+	input := "d1:ai1ee4:spami-3eli1ei2ee"
+	expected := []interface{}{Dict{"a": int64(1)}, []byte("spam"), int64(-3), List{int64(1), int64(2)}}
+
+	// However the input is split into chunks, the same values come out.
+	for size := 1; size <= len(input); size++ {
+		f := NewFeed(WithBytes())
+		var got []interface{}
+		for i := 0; i < len(input); i += size {
+			f.Write([]byte(input[i:min(i+size, len(input))]))
+			for {
+				v, ok := f.Next()
+				if !ok {
+					break
+				}
+				got = append(got, v)
+			}
+		}
+		if err := f.Err(); err != nil {
+			t.Fatalf("chunks of %d: %v", size, err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("chunks of %d:\ngot:      %#v\nexpected: %#v", size, got, expected)
+		}
+	}
+}
+
+func TestFeedError(t *testing.T) {
+	f := NewFeed()
+	f.Write([]byte("i1ei2"))
+	if v, ok := f.Next(); !ok || v != int64(1) {
+		t.Errorf("got %#v, %t; expected 1, true", v, ok)
+	}
+	if _, ok := f.Next(); ok || f.Err() != nil {
+		t.Errorf("got %t, %v for an incomplete value", ok, f.Err())
+	}
+
+	f.Write([]byte("x"))
+	expected := &SyntaxError{"invalid bencoded value", 4}
+	if _, ok := f.Next(); ok || !reflect.DeepEqual(f.Err(), expected) {
+		t.Errorf("\ngot:      %t, %#v\nexpected: false, %#v", ok, f.Err(), expected)
+	}
+	f.Write([]byte("e"))
+	if _, ok := f.Next(); ok {
+		t.Error("expected no values after an error")
+	}
+}
+
+func BenchmarkFeedChunked(b *testing.B) {
+	var input []byte
+	input = append(input, 'l')
+	for len(input) < 1<<20 {
+		input = append(input, "d2:ip8:10.0.0.14:porti6881ee"...)
+	}
+	input = append(input, 'e')
+
+	b.SetBytes(int64(len(input)))
+	for i := 0; i < b.N; i++ {
+		f := NewFeed()
+		n := 0
+		for off := 0; off < len(input); off += 1500 {
+			f.Write(input[off:min(off+1500, len(input))])
+			for {
+				if _, ok := f.Next(); !ok {
+					break
+				}
+				n++
+			}
+		}
+		if n != 1 || f.Err() != nil {
+			b.Fatalf("got %d values, %v", n, f.Err())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// A LazyString refers to a byte string without holding its contents, which
+// are read from the input only when requested. A LazyString decoded by
+// Unmarshal refers to the data passed to it, which must be left unmodified
+// while the LazyString is in use.
+type LazyString struct {
+	r      io.ReaderAt
+	Offset int64 // offset of the contents in the input
+	Len    int64 // length of the contents
+}
+
+// Reader returns a reader of the contents of s.
+func (s LazyString) Reader() *io.SectionReader {
+	return io.NewSectionReader(s.r, s.Offset, s.Len)
+}
+
+// Bytes reads and returns the contents of s.
+func (s LazyString) Bytes() ([]byte, error) {
+	b := make([]byte, s.Len)
+	n, err := s.r.ReadAt(b, s.Offset)
+	if n == len(b) {
+		err = nil
+	}
+	return b, err
+}
+
+// UnmarshalReaderAt decodes the bencoded value at the start of the first
+// size bytes of r into the value pointed to by v, like Unmarshal.
+//
+// Byte strings decoded into LazyString values are skipped over rather than
+// read, as are all byte strings decoded into interface{} values, which are
+// stored as LazyString. This allows large files, such as torrents with
+// gigabytes of piece hashes, to be decoded while reading only the fields
+// that are used.
+func UnmarshalReaderAt(r io.ReaderAt, size int64, v interface{}) error {
+	if err := checkTarget(v); err != nil {
+		return err
+	}
+	sr := io.NewSectionReader(r, 0, size)
+	d := decodeState{r: sr, sr: sr, ra: r, lazy: true, mark: -1}
+	if err := d.fill(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return d.unmarshal(v)
+}
+
+var errLazyStream = errors.New("bencode: cannot decode LazyString from a stream")
+
+// readLazyString consumes a byte string value and stores its location in s.
+// Its contents must be available from an io.ReaderAt: either the input of
+// UnmarshalReaderAt or the data given to Unmarshal.
+func (d *decodeState) readLazyString(s *LazyString) error {
+	n, err := d.readStringLen()
+	if err != nil {
+		return err
+	}
+
+	if d.ra == nil {
+		if d.r != nil {
+			d.saveError(errLazyStream)
+			return d.discard(int64(n))
+		}
+		d.ra = bytes.NewReader(d.data)
+	}
+	*s = LazyString{r: d.ra, Offset: d.offset(), Len: int64(n)}
+	return d.discard(int64(n))
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// countingReaderAt records the number of bytes read from it.
+type countingReaderAt struct {
+	r    io.ReaderAt
+	read int
+}
+
+func (r *countingReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(b, off)
+	r.read += n
+	return n, err
+}
+
+func TestUnmarshalReaderAt(t *testing.T) {
+	pieces := strings.Repeat("x", 1<<20)
+	input := "d4:infod6:lengthi42e6:pieces1048576:" + pieces + "e4:name4:spame"
+	r := &countingReaderAt{r: strings.NewReader(input)}
+
+	var torrent Dict
+	if err := UnmarshalReaderAt(r, int64(len(input)), &torrent); err != nil {
+		t.Fatal(err)
+	}
+	if r.read >= len(pieces) {
+		t.Errorf("read %d bytes; expected pieces to be skipped", r.read)
+	}
+
+	info, ok := torrent["info"].(Dict)
+	if !ok {
+		t.Fatalf("unexpected info %#v", torrent["info"])
+	}
+	if info["length"] != int64(42) {
+		t.Errorf("unexpected length %#v", info["length"])
+	}
+
+	name, ok := torrent["name"].(LazyString)
+	if !ok {
+		t.Fatalf("unexpected name %#v", torrent["name"])
+	}
+	if b, err := name.Bytes(); err != nil || string(b) != "spam" {
+		t.Errorf("got name %q, %v", b, err)
+	}
+
+	p := info["pieces"].(LazyString)
+	if p.Len != int64(len(pieces)) || p.Offset != 36 {
+		t.Errorf("unexpected pieces location %d+%d", p.Offset, p.Len)
+	}
+
+	var truncated Dict
+	err := UnmarshalReaderAt(strings.NewReader(input), int64(len(input))-20, &truncated)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestLazyString(t *testing.T) {
+	var s LazyString
+	if err := Unmarshal([]byte("4:spam"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := s.Bytes(); err != nil || string(b) != "spam" {
+		t.Errorf("got %q, %v", b, err)
+	}
+
+	var l []interface{}
+	if err := NewDecoder(strings.NewReader("l4:spame")).Decode(&l); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(l, []interface{}{"spam"}) {
+		t.Errorf("unexpected %#v", l)
+	}
+
+	if err := NewDecoder(strings.NewReader("4:spam")).Decode(&s); err != errLazyStream {
+		t.Errorf("expected errLazyStream, got %v", err)
+	}
+}
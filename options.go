@@ -0,0 +1,140 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import "time"
+
+// An Option configures an Encoder or a Decoder when passed to NewEncoder or
+// NewDecoder. Options that only concern encoding are ignored by NewDecoder,
+// and those that only concern decoding by NewEncoder, so that one set of
+// options can configure both ends of a connection.
+type Option struct {
+	enc func(*Encoder)
+	dec func(*Decoder)
+}
+
+func encoderOption(fn func(*Encoder)) Option { return Option{enc: fn} }
+func decoderOption(fn func(*Decoder)) Option { return Option{dec: fn} }
+
+// WithKeyFunc derives the keys of struct fields without a name in their tag
+// by calling fn with the field's name, when encoding and decoding. See
+// Encoder.SetKeyFunc.
+func WithKeyFunc(fn func(fieldName string) string) Option {
+	return Option{
+		enc: func(enc *Encoder) { enc.SetKeyFunc(fn) },
+		dec: func(dec *Decoder) { dec.SetKeyFunc(fn) },
+	}
+}
+
+// WithSortKeys sets whether map keys are written in sorted order. See
+// Encoder.SetSortKeys.
+func WithSortKeys(sort bool) Option {
+	return encoderOption(func(enc *Encoder) { enc.SetSortKeys(sort) })
+}
+
+// WithNilPolicy sets how nil values are encoded. See Encoder.SetNilPolicy.
+func WithNilPolicy(policy NilPolicy) Option {
+	return encoderOption(func(enc *Encoder) { enc.SetNilPolicy(policy) })
+}
+
+// WithFloatPolicy sets how floating-point values are encoded, multiplying
+// them by scale under FloatScaled. See Encoder.SetFloatPolicy.
+func WithFloatPolicy(policy FloatPolicy, scale float64) Option {
+	return encoderOption(func(enc *Encoder) {
+		enc.SetFloatPolicy(policy)
+		enc.SetFloatScale(scale)
+	})
+}
+
+// WithBoolStrings encodes bools as the byte strings t and f. See
+// Encoder.SetBoolStrings.
+func WithBoolStrings(t, f string) Option {
+	return encoderOption(func(enc *Encoder) { enc.SetBoolStrings(t, f) })
+}
+
+// WithTimeUnit sets the unit time.Time values are encoded in. See
+// Encoder.SetTimeUnit.
+func WithTimeUnit(unit time.Duration) Option {
+	return encoderOption(func(enc *Encoder) { enc.SetTimeUnit(unit) })
+}
+
+// WithCompactIPs sets whether IP addresses are encoded in their compact
+// binary form. See Encoder.SetCompactIPs.
+func WithCompactIPs(compact bool) Option {
+	return encoderOption(func(enc *Encoder) { enc.SetCompactIPs(compact) })
+}
+
+// WithAtomic sets whether each value is written only once it has been
+// encoded in full. See Encoder.SetAtomic.
+func WithAtomic(atomic bool) Option {
+	return encoderOption(func(enc *Encoder) { enc.SetAtomic(atomic) })
+}
+
+// WithBuffered sets whether the Encoder buffers its output, to be written
+// out by Flush. See Encoder.SetBuffered.
+func WithBuffered(buffered bool) Option {
+	return encoderOption(func(enc *Encoder) { enc.SetBuffered(buffered) })
+}
+
+// WithEncodeHook passes each value through hook before it is encoded. See
+// Encoder.SetEncodeHook.
+func WithEncodeHook(hook EncodeHook) Option {
+	return encoderOption(func(enc *Encoder) { enc.SetEncodeHook(hook) })
+}
+
+// WithBytes decodes byte strings into interface{} values as []byte. See
+// Decoder.UseBytes.
+func WithBytes() Option {
+	return decoderOption((*Decoder).UseBytes)
+}
+
+// WithIntOverflow sets how integers too large for their destination are
+// decoded. See Decoder.SetIntOverflow.
+func WithIntOverflow(mode IntOverflow) Option {
+	return decoderOption(func(dec *Decoder) { dec.SetIntOverflow(mode) })
+}
+
+// WithCaseInsensitiveKeys matches dictionary keys against struct fields
+// case-insensitively. See Decoder.CaseInsensitiveKeys.
+func WithCaseInsensitiveKeys() Option {
+	return decoderOption((*Decoder).CaseInsensitiveKeys)
+}
+
+// WithDisallowUnknownFields reports dictionary keys that match no struct
+// field. See Decoder.DisallowUnknownFields.
+func WithDisallowUnknownFields() Option {
+	return decoderOption((*Decoder).DisallowUnknownFields)
+}
+
+// WithResumable leaves the Decoder positioned at the start of a value that
+// the input ends before, so that it can be decoded once more input arrives.
+// See Decoder.Resumable.
+func WithResumable() Option {
+	return decoderOption((*Decoder).Resumable)
+}
+
+// WithDecodeHook converts decoded values before they are stored. See
+// Decoder.SetDecodeHook.
+func WithDecodeHook(hook DecodeHook) Option {
+	return decoderOption(func(dec *Decoder) { dec.SetDecodeHook(hook) })
+}
+
+// WithProgress calls fn each time the input is read from. See
+// Decoder.SetProgress.
+func WithProgress(fn func(p Progress) error) Option {
+	return decoderOption(func(dec *Decoder) { dec.SetProgress(fn) })
+}
+
+// WithMaxDepth limits how deeply dictionaries and lists may nest. See
+// Decoder.SetMaxDepth.
+func WithMaxDepth(maxDepth int) Option {
+	return decoderOption(func(dec *Decoder) { dec.SetMaxDepth(maxDepth) })
+}
+
+// WithMaxBytes limits the input a Decoder reads to maxBytes bytes. See
+// Decoder.SetMaxBytes.
+func WithMaxBytes(maxBytes int64) Option {
+	return decoderOption(func(dec *Decoder) { dec.SetMaxBytes(maxBytes) })
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testOptions struct {
+	PeerID  string    `bencode:",omitempty"`
+	Private bool      `bencode:",omitempty"`
+	Created time.Time `bencode:",omitempty"`
+	Comment *string
+}
+
+var encoderOptionTests = []struct {
+	opts     []Option
+	input    interface{}
+	expected string
+}{
+	{nil, testOptions{PeerID: "a", Private: true}, "d6:PeerID1:a7:Privatei1ee"},
+	{[]Option{WithKeyFunc(strings.ToLower), WithBoolStrings("yes", "no")}, testOptions{PeerID: "a", Private: true}, "d6:peerid1:a7:private3:yese"},
+	{[]Option{WithNilPolicy(NilEmpty)}, testOptions{}, "d7:Comment0:e"},
+	{[]Option{WithTimeUnit(time.Millisecond)}, testOptions{Created: time.UnixMilli(1500)}, "d7:Createdi1500ee"},
+	{[]Option{WithFloatPolicy(FloatScaled, 100)}, List{0.5}, "li50ee"},
+	{[]Option{WithCompactIPs(true)}, List{netip.MustParseAddr("192.0.2.1")}, "l4:\xc0\x00\x02\x01e"},
+	{[]Option{WithSortKeys(false)}, Dict{"a": 1}, "d1:ai1ee"},
+	{[]Option{WithAtomic(true)}, List{"a"}, "l1:ae"},
+	{[]Option{WithBytes(), WithMaxBytes(1)}, "x", "1:x"},
+}
+
+func TestEncoderOptions(t *testing.T) {
+	for _, test := range encoderOptionTests {
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf, test.opts...).Encode(test.input); err != nil {
+			t.Error(err)
+		} else if buf.String() != test.expected {
+			t.Errorf("\ngot:      %q\nexpected: %q", buf.String(), test.expected)
+		}
+	}
+}
+
+var decoderOptionTests = []struct {
+	opts     []Option
+	input    string
+	target   interface{}
+	expected interface{}
+	err      error
+}{
+	{nil, "d6:PeerID1:ae", new(testOptions), &testOptions{PeerID: "a"}, nil},
+	{[]Option{WithKeyFunc(strings.ToLower)}, "d6:peerid1:ae", new(testOptions), &testOptions{PeerID: "a"}, nil},
+	{[]Option{WithCaseInsensitiveKeys()}, "d6:PEERID1:ae", new(testOptions), &testOptions{PeerID: "a"}, nil},
+	{[]Option{WithDisallowUnknownFields()}, "d1:x1:ae", new(testOptions), &testOptions{}, &UnknownFieldError{Struct: "testOptions", Field: "x", Offset: 1}},
+	{[]Option{WithBytes()}, "1:a", new(interface{}), ptrTo[interface{}]([]byte("a")), nil},
+	{[]Option{WithIntOverflow(IntOverflowSaturate)}, "i300e", new(int8), ptrTo[int8](127), nil},
+	{[]Option{WithMaxBytes(4)}, "5:abcde", new(string), new(string), ErrTooLarge},
+	{[]Option{WithDecodeHook(func(to reflect.Type, data interface{}) (interface{}, error) {
+		if s, ok := data.(string); ok && to.Kind() == reflect.String {
+			return strings.ToUpper(s), nil
+		}
+		return data, nil
+	})}, "d6:PeerID1:ae", new(testOptions), &testOptions{PeerID: "A"}, nil},
+	{[]Option{WithSortKeys(false), WithCompactIPs(true)}, "1:a", new(string), ptrTo("a"), nil},
+}
+
+func ptrTo[T any](v T) *T { return &v }
+
+func TestDecoderOptions(t *testing.T) {
+	for _, test := range decoderOptionTests {
+		err := NewDecoder(strings.NewReader(test.input), test.opts...).Decode(test.target)
+		if !reflect.DeepEqual(err, test.err) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", err, test.err)
+		} else if !reflect.DeepEqual(test.target, test.expected) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", test.target, test.expected)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+// A Handler holds the callbacks by which Decoder.Parse reports the parts of
+// a value as they are read. Any of them may be nil. The byte slices passed
+// to OnKey and OnString are only valid until the callback returns.
+//
+// An error returned by a callback stops the parse, and is returned by
+// Parse.
+type Handler struct {
+	OnDictStart func() error
+	OnDictEnd   func() error
+	OnListStart func() error
+	OnListEnd   func() error
+	OnKey       func(key []byte) error
+	OnString    func(s []byte) error
+	OnInt       func(n int64) error
+}
+
+// Parse reads the next bencoded value from its input and reports its parts
+// to h in order, without building any Go values, for tools that index or
+// validate large numbers of files. Like Decode, it returns io.EOF if the
+// input is exhausted before the value begins. An integer that does not fit
+// in an int64 is reported as an *UnmarshalTypeError.
+func (dec *Decoder) Parse(h *Handler) error {
+	if err := dec.begin(); err != nil {
+		return err
+	}
+	return dec.d.parse(h)
+}
+
+// parse consumes the next value, reporting its parts to h.
+func (d *decodeState) parse(h *Handler) error {
+	c, err := d.peekValue()
+	if err != nil {
+		return err
+	}
+
+	switch c {
+	case 'i':
+		n, err := d.readInt64()
+		if err != nil || h.OnInt == nil {
+			return err
+		}
+		return h.OnInt(n)
+
+	case 'l', 'd':
+		if err := d.enter(); err != nil {
+			return err
+		}
+		defer d.leave()
+		d.off++
+		start, end := h.OnListStart, h.OnListEnd
+		if c == 'd' {
+			start, end = h.OnDictStart, h.OnDictEnd
+		}
+		if start != nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		for {
+			ok, err := d.more()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if c == 'd' {
+				key, err := d.readKeyBytes()
+				if err != nil {
+					return err
+				}
+				if h.OnKey != nil {
+					if err := h.OnKey(key); err != nil {
+						return err
+					}
+				}
+			}
+			if err := d.parse(h); err != nil {
+				return err
+			}
+		}
+		if end != nil {
+			return end()
+		}
+		return nil
+
+	default:
+		if h.OnString == nil {
+			return d.skipString()
+		}
+		s, err := d.readString()
+		if err != nil {
+			return err
+		}
+		return h.OnString(s)
+	}
+}
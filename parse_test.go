@@ -0,0 +1,89 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// recordHandler returns a Handler that appends a description of each
+// callback to events.
+func recordHandler(events *[]string) *Handler {
+	event := func(s string) func() error {
+		return func() error { *events = append(*events, s); return nil }
+	}
+	return &Handler{
+		OnDictStart: event("d"),
+		OnDictEnd:   event("/d"),
+		OnListStart: event("l"),
+		OnListEnd:   event("/l"),
+		OnKey: func(key []byte) error {
+			*events = append(*events, "key "+string(key))
+			return nil
+		},
+		OnString: func(s []byte) error {
+			*events = append(*events, "str "+string(s))
+			return nil
+		},
+		OnInt: func(n int64) error {
+			*events = append(*events, "int "+strconv.FormatInt(n, 10))
+			return nil
+		},
+	}
+}
+
+var parseTests = []struct {
+	input    string
+	expected []string
+	err      error
+}{
+	{"i-3e", []string{"int -3"}, nil},
+	{"4:spam", []string{"str spam"}, nil},
+	{"d4:infod6:lengthi5eee", []string{"d", "key info", "d", "key length", "int 5", "/d", "/d"}, nil},
+	{"ll0:ee", []string{"l", "l", "str ", "/l", "/l"}, nil},
+	{"l1:a", []string{"l", "str a"}, io.ErrUnexpectedEOF},
+	{"di1ei2ee", []string{"d"}, &SyntaxError{"dict key is not a string", 1}},
+	{"", nil, io.EOF},
+}
+
+func TestDecoderParse(t *testing.T) {
+	for _, test := range parseTests {
+		var events []string
+		dec := NewDecoder(iotest.OneByteReader(strings.NewReader(test.input)))
+		err := dec.Parse(recordHandler(&events))
+		if !reflect.DeepEqual(events, test.expected) || !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%q:\ngot:      %#v, %#v\nexpected: %#v, %#v", test.input, events, err, test.expected, test.err)
+		}
+	}
+}
+
+func TestDecoderParseStop(t *testing.T) {
+	errStop := errors.New("stop")
+	dec := NewDecoder(strings.NewReader("d1:ai1e1:bi2ee1:x"))
+	var keys []string
+	err := dec.Parse(&Handler{OnKey: func(key []byte) error {
+		keys = append(keys, string(key))
+		return errStop
+	}})
+	if err != errStop || !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Errorf("got %#v, %v; expected [a], %v", keys, err, errStop)
+	}
+
+	// A nil Handler field skips that part of the value.
+	dec = NewDecoder(strings.NewReader("d1:ai1ee1:x"))
+	if err := dec.Parse(&Handler{}); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := dec.Decode(&s); err != nil || s != "x" {
+		t.Errorf("got %q, %v; expected \"x\"", s, err)
+	}
+}
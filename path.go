@@ -0,0 +1,122 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrPathNotFound is returned by ExtractPath if the input has no value at
+// the requested path.
+var ErrPathNotFound = errors.New("bencode: path not found")
+
+// ExtractPath returns the encoding of the value at path within the bencoded
+// value in data, without decoding anything else. path is a sequence of
+// dot-separated elements, each of which is a dictionary key or, for lists,
+// a decimal index; "info.pieces" and "announce-list.0.0" are examples. An
+// empty path refers to the whole value. Keys containing dots cannot be
+// addressed.
+//
+// The result is a subslice of data.
+func ExtractPath(data []byte, path string) ([]byte, error) {
+	d := decodeState{data: data, mark: -1}
+	if path != "" {
+		for _, elem := range strings.Split(path, ".") {
+			if err := d.seek(elem); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	start := d.off
+	if err := d.skip(); err != nil {
+		return nil, err
+	}
+	return data[start:d.off], nil
+}
+
+// SeekKey locates the value of the entry with the given key in the bencoded
+// dictionary in data, returning its span as data[start:end]. Only the key
+// and the values before it are looked at, without building any Go values,
+// so grabbing the info dictionary of a torrent or the peers of an announce
+// response this way is cheaper than any other. If data holds no such entry,
+// or is not a dictionary, SeekKey returns ErrPathNotFound. Unlike with
+// ExtractPath, key may contain dots.
+func SeekKey(data []byte, key string) (start, end int, err error) {
+	if len(data) > 0 && data[0] != 'd' {
+		return 0, 0, ErrPathNotFound
+	}
+	d := decodeState{data: data, mark: -1}
+	if err := d.seek(key); err != nil {
+		return 0, 0, err
+	}
+	start = d.off
+	if err := d.skip(); err != nil {
+		return 0, 0, err
+	}
+	return start, d.off, nil
+}
+
+// seek consumes input up to the start of the element named elem of the
+// next value: the entry with key elem of a dictionary, or the element with
+// index elem of a list. It returns ErrPathNotFound if there is no such
+// element.
+func (d *decodeState) seek(elem string) error {
+	c, err := d.peekValue()
+	if err != nil {
+		return err
+	}
+
+	switch c {
+	case 'd':
+		d.off++
+		for {
+			ok, err := d.more()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrPathNotFound
+			}
+
+			key, err := d.readKeyBytes()
+			if err != nil {
+				return err
+			}
+			if string(key) == elem {
+				return nil
+			}
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+
+	case 'l':
+		i, err := strconv.Atoi(elem)
+		if err != nil || i < 0 {
+			return ErrPathNotFound
+		}
+		d.off++
+		for ; ; i-- {
+			ok, err := d.more()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrPathNotFound
+			}
+			if i == 0 {
+				return nil
+			}
+			if err := d.skip(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return ErrPathNotFound
+}
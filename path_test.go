@@ -0,0 +1,83 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"io"
+	"testing"
+)
+
+const pathTestInput = "d8:announce3:url13:announce-listll2:t1el3:t2a3:t2bee4:infod6:lengthi42e6:pieces6:abcdefee"
+
+var extractPathTests = []struct {
+	path     string
+	expected string
+	err      error
+}{
+	{"", pathTestInput, nil},
+	{"announce", "3:url", nil},
+	{"info", "d6:lengthi42e6:pieces6:abcdefe", nil},
+	{"info.pieces", "6:abcdef", nil},
+	{"info.length", "i42e", nil},
+	{"announce-list.1", "l3:t2a3:t2be", nil},
+	{"announce-list.1.1", "3:t2b", nil},
+	{"announce-list.2", "", ErrPathNotFound},
+	{"announce-list.x", "", ErrPathNotFound},
+	{"info.name", "", ErrPathNotFound},
+	{"announce.x", "", ErrPathNotFound},
+	{"missing", "", ErrPathNotFound},
+}
+
+func TestExtractPath(t *testing.T) {
+	for _, test := range extractPathTests {
+		got, err := ExtractPath([]byte(pathTestInput), test.path)
+		if err != test.err {
+			t.Errorf("%q: got error %v, expected %v", test.path, err, test.err)
+		} else if string(got) != test.expected {
+			t.Errorf("%q:\ngot:      %s\nexpected: %s", test.path, got, test.expected)
+		}
+	}
+
+	if _, err := ExtractPath([]byte("d4:info"), "info"); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+var seekKeyTests = []struct {
+	input    string
+	key      string
+	expected string
+	err      error
+}{
+	{pathTestInput, "announce", "3:url", nil},
+	{pathTestInput, "info", "d6:lengthi42e6:pieces6:abcdefe", nil},
+	{pathTestInput, "pieces", "", ErrPathNotFound},
+	{"d3:a.bi1ee", "a.b", "i1e", nil},
+	{"l1:ae", "0", "", ErrPathNotFound},
+	{"i1e", "x", "", ErrPathNotFound},
+	{"d1:ai1e", "b", "", io.ErrUnexpectedEOF},
+	{"", "a", "", io.ErrUnexpectedEOF},
+}
+
+func TestSeekKey(t *testing.T) {
+	for _, test := range seekKeyTests {
+		start, end, err := SeekKey([]byte(test.input), test.key)
+		if err != test.err {
+			t.Errorf("%q: got error %v, expected %v", test.key, err, test.err)
+		} else if got := test.input[start:end]; got != test.expected {
+			t.Errorf("%q:\ngot:      %s\nexpected: %s", test.key, got, test.expected)
+		}
+	}
+
+	data := []byte(pathTestInput)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, _, err := SeekKey(data, "info"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per SeekKey, expected 0", allocs)
+	}
+}
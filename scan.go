@@ -0,0 +1,213 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"io"
+)
+
+// Valid reports whether data is a single well-formed bencoded value, with
+// nothing after it, as UnmarshalStrict requires. It builds no values and
+// does not allocate for values nested less than 64 deep, so that it can be
+// used to reject malformed input cheaply before decoding it in earnest.
+// As for Unmarshal, dictionary keys need not be in sorted order.
+func Valid(data []byte) bool {
+	n, status := scanValue(data)
+	return status == scanOK && n == len(data)
+}
+
+// ScanValues is a split function for a bufio.Scanner that returns each
+// bencoded value in the input as a token, for framing a stream of
+// concatenated values such as KRPC messages sent over TCP:
+//
+//	s := bufio.NewScanner(conn)
+//	s.Split(bencode.ScanValues)
+//	for s.Scan() {
+//		var msg Message
+//		if err := bencode.Unmarshal(s.Bytes(), &msg); err != nil {
+//			...
+//		}
+//	}
+//
+// Malformed input stops the scan with a *SyntaxError, whose offset is
+// relative to the start of the value, and input that ends in the middle of
+// a value with io.ErrUnexpectedEOF. Values longer than the Scanner's
+// maximum token size, 64KB by default, also stop the scan; call the
+// Scanner's Buffer method to allow larger ones.
+func ScanValues(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	n, status := scanValue(data)
+	switch status {
+	case scanOK:
+		return n, data[:n], nil
+	case scanShort:
+		if atEOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	default:
+		return 0, nil, &SyntaxError{"invalid bencoded value", int64(n)}
+	}
+}
+
+// A scanStatus is the outcome of scanning a value.
+type scanStatus int
+
+const (
+	scanOK      scanStatus = iota
+	scanShort              // the value is incomplete
+	scanInvalid            // the value is malformed
+)
+
+// scanValue checks the bencoded value at the start of data, without
+// building it. It returns the length of the value and scanOK if it is well
+// formed, the offset at which it is malformed and scanInvalid, or scanShort
+// if data ends before the value does.
+func scanValue(data []byte) (int, scanStatus) {
+	var buf [64]byte
+	_, n, status := scanner{stack: buf[:0]}.scan(data)
+	return n, status
+}
+
+// A scanner checks a bencoded value that may arrive in parts, keeping its
+// place between calls so that each byte is scanned only once, bar those of
+// a partly arrived integer or byte string length.
+type scanner struct {
+	off int // offset of the first byte not yet scanned
+
+	// Each container being scanned is recorded as 'l' for a list, or for a
+	// dictionary as 'k' if a key is next and 'v' if a value is.
+	stack []byte
+}
+
+// reset prepares the scanner to scan a new value.
+func (s *scanner) reset() {
+	s.off, s.stack = 0, s.stack[:0]
+}
+
+// scan carries on checking the value at the start of data, as scanValue
+// does, returning the scanner's new state along with the result. Once the
+// result is scanShort, scanning may carry on from that state with the same
+// data extended by more input. s is taken by value, as the scanner's stack
+// may be held in the caller's frame.
+func (s scanner) scan(data []byte) (scanner, int, scanStatus) {
+	for {
+		off := s.off
+		if off >= len(data) {
+			return s, off, scanShort
+		}
+		c := data[off]
+		var top *byte
+		var prev byte
+		if len(s.stack) > 0 {
+			top = &s.stack[len(s.stack)-1]
+			if c == 'e' && *top != 'v' {
+				s.off++
+				s.stack = s.stack[:len(s.stack)-1]
+				if len(s.stack) == 0 {
+					return s, s.off, scanOK
+				}
+				continue
+			}
+			prev = *top
+			switch *top {
+			case 'k':
+				if !isDigit(c) {
+					return s, off, scanInvalid
+				}
+				*top = 'v'
+			case 'v':
+				*top = 'k'
+			}
+		}
+
+		switch {
+		case c == 'l':
+			s.stack = append(s.stack, 'l')
+			s.off++
+			continue
+
+		case c == 'd':
+			s.stack = append(s.stack, 'k')
+			s.off++
+			continue
+
+		case c == 'i' || isDigit(c):
+			end, status := scanScalar(data, off)
+			switch status {
+			case scanShort:
+				// The scalar is scanned again in full once more of it
+				// has arrived.
+				if top != nil {
+					*top = prev
+				}
+				return s, len(data), scanShort
+			case scanInvalid:
+				return s, end, scanInvalid
+			}
+			s.off = end
+
+		default:
+			return s, off, scanInvalid
+		}
+
+		if len(s.stack) == 0 {
+			return s, s.off, scanOK
+		}
+	}
+}
+
+// scanScalar checks the integer or byte string at data[off:]. It returns
+// the offset of its end and scanOK if it is well formed, the offset at
+// which it is malformed and scanInvalid, or scanShort if data ends before
+// it does.
+func scanScalar(data []byte, off int) (int, scanStatus) {
+	if data[off] == 'i' {
+		i := bytes.IndexByte(data[off+1:], 'e')
+		if i < 0 {
+			if !validIntPrefix(data[off+1:]) {
+				return off + 1, scanInvalid
+			}
+			return len(data), scanShort
+		}
+		if !validInt(data[off+1 : off+1+i]) {
+			return off + 1, scanInvalid
+		}
+		return off + i + 2, scanOK
+	}
+
+	i := bytes.IndexByte(data[off:], ':')
+	if i < 0 {
+		if !validIntPrefix(data[off:]) {
+			return off, scanInvalid
+		}
+		return len(data), scanShort
+	}
+	b := data[off : off+i]
+	n, ok := uint64(0), validInt(b)
+	if ok {
+		n, ok = parseUint(b)
+	}
+	if !ok || n > maxInt {
+		return off, scanInvalid
+	}
+	off += i + 1
+	if n > uint64(len(data)-off) {
+		return len(data), scanShort
+	}
+	return off + int(n), scanOK
+}
+
+// validIntPrefix reports whether b, which holds no terminator, may be the
+// start of the digits of an integer.
+func validIntPrefix(b []byte) bool {
+	if len(b) == 0 || (len(b) == 1 && b[0] == '-') {
+		return true
+	}
+	return validInt(b)
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var validTests = []struct {
+	input    string
+	expected bool
+}{
+	{"i0e", true},
+	{"i-42e", true},
+	{"i123456789012345678901234567890e", true},
+	{"4:spam", true},
+	{"0:", true},
+	{"le", true},
+	{"de", true},
+	{"d1:bi1e1:ali2eee", true},
+	{"d4:infod6:lengthi1e4:name1:x6:pieces0:ee", true},
+	{strings.Repeat("l", 100) + strings.Repeat("e", 100), true},
+
+	{"", false},
+	{"i01e", false},
+	{"i-0e", false},
+	{"ie", false},
+	{"i1", false},
+	{"5:spam", false},
+	{"01:a", false},
+	{"-1:a", false},
+	{"l", false},
+	{"e", false},
+	{"di1ei2ee", false},
+	{"d1:ae", false},
+	{"i1ei2e", false},
+	{"x", false},
+	{strings.Repeat("l", 100) + strings.Repeat("e", 99), false},
+}
+
+func TestValid(t *testing.T) {
+	for _, test := range validTests {
+		if got := Valid([]byte(test.input)); got != test.expected {
+			t.Errorf("%q: got %t, expected %t", test.input, got, test.expected)
+		}
+		// Valid agrees with UnmarshalStrict.
+		var v RawBytes
+		if err := UnmarshalStrict([]byte(test.input), &v); (err == nil) != test.expected {
+			t.Errorf("%q: UnmarshalStrict returned %v", test.input, err)
+		}
+	}
+}
+
+func TestValidAllocs(t *testing.T) {
+	inputs := [][]byte{
+		[]byte("d8:completei5e5:peersld2:ip8:10.0.0.14:porti6881eee8:intervali1800ee"),
+		[]byte("d8:completei5e5:peersld2:ip8:10.0.0.14:porti6881eee8:intervali01ee"),
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, input := range inputs {
+			Valid(input)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations per Valid, expected 0", allocs)
+	}
+}
+
+var scanValuesTests = []struct {
+	input    string
+	expected []string
+	err      error
+}{
+	{"", nil, nil},
+	{"i1e4:spamled1:ai1ee", []string{"i1e", "4:spam", "le", "d1:ai1ee"}, nil},
+	{"d1:ai1eei2", []string{"d1:ai1ee"}, io.ErrUnexpectedEOF},
+	{"i1ed1:ai01ee", []string{"i1e"}, &SyntaxError{"invalid bencoded value", 5}},
+}
+
+func TestScanValues(t *testing.T) {
+	for _, test := range scanValuesTests {
+		s := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(test.input)))
+		s.Split(ScanValues)
+		var got []string
+		for s.Scan() {
+			got = append(got, s.Text())
+		}
+		if !reflect.DeepEqual(got, test.expected) || !reflect.DeepEqual(s.Err(), test.err) {
+			t.Errorf("%q:\ngot:      %#v, %#v\nexpected: %#v, %#v", test.input, got, s.Err(), test.expected, test.err)
+		}
+	}
+}
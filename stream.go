@@ -0,0 +1,148 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"errors"
+	"io"
+	"strconv"
+)
+
+// A streamFrame tracks a dictionary or list begun on an Encoder and not yet
+// ended.
+type streamFrame struct {
+	dict    bool
+	haveKey bool   // a key has been written and awaits its value
+	hasKeys bool   // at least one key has been written
+	lastKey string // the key written last, if hasKeys
+}
+
+var (
+	errNoKey       = errors.New("bencode: dictionary value written without a key")
+	errKeyNoValue  = errors.New("bencode: dictionary key has no value")
+	errKeyNotDict  = errors.New("bencode: Key called outside a dictionary")
+	errEndNotBegun = errors.New("bencode: End called without a matching Begin")
+)
+
+// BeginDict writes the start of a dictionary, so that its entries can be
+// written one at a time, each by a call to Key followed by the value, and
+// the dictionary ended by End. This allows a dictionary to be streamed out
+// without holding all of it in memory, as in:
+//
+//	enc.BeginDict()
+//	enc.Key("peers")
+//	enc.BeginList()
+//	for _, p := range peers {
+//		enc.Encode(p)
+//	}
+//	enc.End()
+//	enc.End()
+//
+// Values are written by Encode, or by BeginDict or BeginList to begin a
+// nested dictionary or list. Keys must be given in sorted order, unless the
+// Encoder is set not to sort keys.
+func (enc *Encoder) BeginDict() error {
+	if err := enc.beginValue(); err != nil {
+		return err
+	}
+	enc.stack = append(enc.stack, streamFrame{dict: true})
+	_, err := enc.e.Write([]byte{'d'})
+	return err
+}
+
+// BeginList writes the start of a list, whose elements are then written one
+// at a time until the list is ended by End. See BeginDict.
+func (enc *Encoder) BeginList() error {
+	if err := enc.beginValue(); err != nil {
+		return err
+	}
+	enc.stack = append(enc.stack, streamFrame{})
+	_, err := enc.e.Write([]byte{'l'})
+	return err
+}
+
+// Key writes the key of the next entry of the dictionary begun by the
+// innermost call to BeginDict.
+func (enc *Encoder) Key(key string) error {
+	if len(enc.stack) == 0 || !enc.stack[len(enc.stack)-1].dict {
+		return errKeyNotDict
+	}
+	f := &enc.stack[len(enc.stack)-1]
+	if f.haveKey {
+		return errKeyNoValue
+	}
+	if f.hasKeys && !enc.e.unsorted && key <= f.lastKey {
+		return errors.New("bencode: key " + strconv.Quote(key) + " out of order after " + strconv.Quote(f.lastKey))
+	}
+	f.haveKey, f.hasKeys, f.lastKey = true, true, key
+	enc.e.marshalString(key)
+	return enc.e.err
+}
+
+// End writes the end of the dictionary or list begun by the innermost call
+// to BeginDict or BeginList.
+func (enc *Encoder) End() error {
+	if len(enc.stack) == 0 {
+		return errEndNotBegun
+	}
+	if enc.stack[len(enc.stack)-1].haveKey {
+		return errKeyNoValue
+	}
+	enc.stack = enc.stack[:len(enc.stack)-1]
+	_, err := enc.e.Write([]byte{'e'})
+	return err
+}
+
+// EncodeStringFrom writes a byte string of the n bytes read from r, without
+// holding them in memory, as for the gigabytes of piece hashes of a large
+// torrent. If r holds fewer than n bytes, EncodeStringFrom returns
+// io.ErrUnexpectedEOF; as the stream then holds a partial value, every later
+// call to the Encoder returns the error too. The value is written as it is
+// read, even by an Encoder set to write values atomically.
+func (enc *Encoder) EncodeStringFrom(r io.Reader, n int64) error {
+	if n < 0 {
+		return errors.New("bencode: negative byte string length")
+	}
+	if err := enc.beginValue(); err != nil {
+		return err
+	}
+	enc.e.Write(strconv.AppendInt(nil, n, 10))
+	enc.e.Write([]byte{':'})
+	if enc.e.err != nil {
+		return enc.e.err
+	}
+	if _, err := io.CopyN(&enc.e, r, n); err != nil && enc.e.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		enc.e.err = err
+	}
+	return enc.e.err
+}
+
+// beginValue checks that a value may be written next, and records that the
+// current dictionary key, if any, has been given its value.
+func (enc *Encoder) beginValue() error {
+	if len(enc.stack) == 0 {
+		return nil
+	}
+	f := &enc.stack[len(enc.stack)-1]
+	if f.dict {
+		if !f.haveKey {
+			return errNoKey
+		}
+		f.haveKey = false
+	}
+	return nil
+}
+
+// unbeginValue undoes beginValue, once the value it began has failed to
+// encode without any of it being written.
+func (enc *Encoder) unbeginValue() {
+	if len(enc.stack) > 0 {
+		f := &enc.stack[len(enc.stack)-1]
+		f.haveKey = f.dict
+	}
+}
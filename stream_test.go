@@ -0,0 +1,199 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEncoderStream(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	steps := []error{
+		enc.BeginDict(),
+		enc.Key("interval"),
+		enc.Encode(1800),
+		enc.Key("peers"),
+		enc.BeginList(),
+	}
+	for i := 0; i < 3; i++ {
+		steps = append(steps,
+			enc.BeginDict(),
+			enc.Key("ip"),
+			enc.Encode("10.0.0.1"),
+			enc.Key("port"),
+			enc.Encode(6881+i),
+			enc.End(),
+		)
+	}
+	steps = append(steps,
+		enc.End(),
+		enc.Key("tracker id"),
+		enc.Encode("x"),
+		enc.End(),
+		enc.EncodeAll("a", List{}),
+	)
+	for i, err := range steps {
+		if err != nil {
+			t.Errorf("step %d: %v", i, err)
+		}
+	}
+
+	expected := "d8:intervali1800e5:peersl" +
+		"d2:ip8:10.0.0.14:porti6881ee" +
+		"d2:ip8:10.0.0.14:porti6882ee" +
+		"d2:ip8:10.0.0.14:porti6883ee" +
+		"e10:tracker id1:xe1:ale"
+	if buf.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), expected)
+	}
+}
+
+func TestEncoderStreamError(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps func(enc *Encoder) error
+	}{
+		{"key outside dict", func(enc *Encoder) error { return enc.Key("a") }},
+		{"key in list", func(enc *Encoder) error {
+			enc.BeginList()
+			return enc.Key("a")
+		}},
+		{"value without key", func(enc *Encoder) error {
+			enc.BeginDict()
+			return enc.Encode(1)
+		}},
+		{"dict without key", func(enc *Encoder) error {
+			enc.BeginDict()
+			return enc.BeginList()
+		}},
+		{"two keys", func(enc *Encoder) error {
+			enc.BeginDict()
+			enc.Key("a")
+			return enc.Key("b")
+		}},
+		{"end after key", func(enc *Encoder) error {
+			enc.BeginDict()
+			enc.Key("a")
+			return enc.End()
+		}},
+		{"unsorted keys", func(enc *Encoder) error {
+			enc.BeginDict()
+			enc.Key("b")
+			enc.Encode(1)
+			return enc.Key("a")
+		}},
+		{"duplicate keys", func(enc *Encoder) error {
+			enc.BeginDict()
+			enc.Key("a")
+			enc.Encode(1)
+			return enc.Key("a")
+		}},
+		{"end without begin", func(enc *Encoder) error {
+			enc.BeginList()
+			enc.End()
+			return enc.End()
+		}},
+	}
+	for _, test := range tests {
+		if err := test.steps(NewEncoder(&bytes.Buffer{})); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, WithSortKeys(false))
+	for _, err := range []error{enc.BeginDict(), enc.Key("b"), enc.Encode(1), enc.Key("a"), enc.Encode(2), enc.End()} {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if expected := "d1:bi1e1:ai2ee"; buf.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), expected)
+	}
+}
+
+func TestEncoderStreamValueError(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithAtomic(true)}, {WithBuffered(true)}} {
+		// A value that fails before any of it is written leaves the key
+		// awaiting a value.
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf, opts...)
+		enc.BeginDict()
+		enc.Key("a")
+		if err := enc.Encode(make(chan int)); err == nil {
+			t.Errorf("%v: expected error encoding a chan", opts)
+		}
+		if err := enc.Key("b"); err != errKeyNoValue {
+			t.Errorf("%v: got %v after a failed value, expected %v", opts, err, errKeyNoValue)
+		}
+		for _, err := range []error{enc.Encode(1), enc.End(), enc.Flush()} {
+			if err != nil {
+				t.Errorf("%v: %v", opts, err)
+			}
+		}
+		if expected := "d1:ai1ee"; buf.String() != expected {
+			t.Errorf("%v:\ngot:      %s\nexpected: %s", opts, buf.String(), expected)
+		}
+	}
+
+	// A value that fails after part of it is written stops the Encoder.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.BeginDict()
+	enc.Key("a")
+	err := enc.Encode(List{1, make(chan int)})
+	if err == nil {
+		t.Fatal("expected error encoding a chan")
+	}
+	for _, later := range []error{enc.Key("b"), enc.Encode(1), enc.End()} {
+		if later != err {
+			t.Errorf("got %v after a partly written value, expected %v", later, err)
+		}
+	}
+}
+
+func TestEncoderEncodeStringFrom(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	pieces := strings.Repeat("0123456789abcdefghij", 5000)
+	steps := []error{
+		enc.BeginDict(),
+		enc.Key("pieces"),
+		enc.EncodeStringFrom(strings.NewReader(pieces+"trailing"), int64(len(pieces))),
+		enc.End(),
+		enc.EncodeStringFrom(strings.NewReader(""), 0),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Errorf("step %d: %v", i, err)
+		}
+	}
+	if expected := "d6:pieces100000:" + pieces + "e0:"; buf.String() != expected {
+		t.Errorf("\ngot:      %.40s... (%d bytes)\nexpected: %.40s... (%d bytes)", buf.String(), buf.Len(), expected, len(expected))
+	}
+
+	enc = NewEncoder(io.Discard)
+	if err := enc.EncodeStringFrom(strings.NewReader("abc"), 4); err != io.ErrUnexpectedEOF {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", err, io.ErrUnexpectedEOF)
+	}
+	if err := enc.Encode(1); err != io.ErrUnexpectedEOF {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", err, io.ErrUnexpectedEOF)
+	}
+
+	errRead := errors.New("read failed")
+	enc = NewEncoder(io.Discard)
+	if err := enc.EncodeStringFrom(io.MultiReader(strings.NewReader("ab"), iotest.ErrReader(errRead)), 4); err != errRead {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", err, errRead)
+	}
+	if err := NewEncoder(io.Discard).EncodeStringFrom(strings.NewReader(""), -1); err == nil {
+		t.Error("expected error for negative length")
+	}
+}
@@ -0,0 +1,856 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A field describes a struct field that is encoded as a dictionary entry.
+type field struct {
+	name      string // dictionary key
+	key       []byte // encoding of name
+	index     []int  // index sequence of the field, through embedded structs
+	tagged    bool   // whether the key was given by a tag
+	omitEmpty bool   // whether the entry is left out for empty values
+	required  bool   // whether the entry must be present when decoding
+	def       []byte // encoding of the value decoded if the entry is missing
+	milli     bool   // whether a time.Time is in milliseconds
+	asString  bool   // whether a bool or integer is encoded as a byte string
+	rules     *rules // constraints checked when decoding, or nil
+}
+
+// rules holds the constraints given by a struct field's validation options.
+// The bounds apply to the value of integers and to the length of strings,
+// slices, arrays and maps.
+type rules struct {
+	min, max       int64
+	hasMin, hasMax bool
+	length         int // exact length, or -1
+	nonEmpty       bool
+}
+
+// structFields describes how a struct type is encoded.
+type structFields struct {
+	list   []field        // fields in key order
+	byName map[string]int // index into list by key
+	inline []int          // index sequence of the inline map, if any
+
+	checked int // number of required fields and fields with a default
+
+	// err is the first malformed decoding option found in a field's tag,
+	// reported when a value of the type is decoded.
+	err error
+}
+
+// fieldCache holds the structFields of each struct type seen so far, so
+// that its fields are only examined, and their keys encoded, once.
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// A keyFunc maps the names of struct fields without a name in their tag to
+// dictionary keys. As the keys determine how a struct is encoded, it keeps
+// its own cache of structFields.
+type keyFunc struct {
+	fn     func(string) string
+	fields sync.Map // map[reflect.Type]*structFields
+}
+
+// newKeyFunc returns a keyFunc calling fn, or nil if fn is nil.
+func newKeyFunc(fn func(string) string) *keyFunc {
+	if fn == nil {
+		return nil
+	}
+	return &keyFunc{fn: fn}
+}
+
+// cachedFields returns the structFields of the struct type t, with keys
+// mapped by keys if it is not nil.
+func cachedFields(t reflect.Type, keys *keyFunc) *structFields {
+	cache := &fieldCache
+	if keys != nil {
+		cache = &keys.fields
+	}
+	if f, ok := cache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := cache.LoadOrStore(t, typeFields(t, keys))
+	return f.(*structFields)
+}
+
+// typeFields returns the structFields of the struct type t: an entry for
+// each exported field not tagged "-", keyed by the name in its bencode tag
+// or else by the field's name, as mapped by keys if it is not nil.
+//
+// The fields of untagged embedded structs are promoted as if they were
+// fields of t, following the visibility rules of Go and encoding/json: of
+// several fields with the same key, the one nested least deeply wins, then
+// the one with a tag. If that leaves more than one, all of them are left
+// out. Struct fields with the inline option are promoted in the same way.
+//
+// A map field with string keys and the inline or rest option holds the
+// entries that have no corresponding field. Only the first one found is
+// used.
+func typeFields(t reflect.Type, keys *keyFunc) *structFields {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var list []field
+	var inline []int
+	var tagErr error
+	visited := map[reflect.Type]bool{}
+	for next := []embedded{{typ: t}}; len(next) > 0; {
+		current := next
+		next = nil
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				if sf.PkgPath != "" && !sf.Anonymous {
+					continue // unexported
+				}
+				tag := sf.Tag.Get("bencode")
+				if tag == "-" {
+					continue
+				}
+				name, opts := parseTag(tag)
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				index := make([]int, len(e.index)+1)
+				copy(index, e.index)
+				index[len(e.index)] = i
+
+				if ft.Kind() == reflect.Struct && (sf.Anonymous && name == "" || opts.Contains("inline")) {
+					next = append(next, embedded{typ: ft, index: index})
+					continue
+				}
+				if sf.PkgPath != "" {
+					continue // unexported embedded non-struct
+				}
+				if (opts.Contains("inline") || opts.Contains("rest")) && sf.Type.Kind() == reflect.Map && sf.Type.Key().Kind() == reflect.String {
+					if inline == nil {
+						inline = index
+					}
+					continue
+				}
+				def, err := defaultValue(sf.Type, opts)
+				if err != nil && tagErr == nil {
+					tagErr = errors.New("bencode: " + err.Error() + " in the tag of field " + e.typ.String() + "." + sf.Name)
+				}
+				rules, err := parseRules(opts)
+				if err != nil && tagErr == nil {
+					tagErr = errors.New("bencode: " + err.Error() + " in the tag of field " + e.typ.String() + "." + sf.Name)
+				}
+				tagged := name != ""
+				if !tagged {
+					name = sf.Name
+					if keys != nil {
+						name = keys.fn(name)
+					}
+				}
+				list = append(list, field{
+					name:      name,
+					index:     index,
+					tagged:    tagged,
+					omitEmpty: opts.Contains("omitempty"),
+					required:  opts.Contains("required"),
+					def:       def,
+					milli:     sf.Type == timeType && opts.Contains("unixmilli"),
+					asString:  isScalar(sf.Type.Kind()) && opts.Contains("string"),
+					rules:     rules,
+				})
+			}
+		}
+		for _, e := range current {
+			visited[e.typ] = true
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].name != list[j].name {
+			return list[i].name < list[j].name
+		}
+		if len(list[i].index) != len(list[j].index) {
+			return len(list[i].index) < len(list[j].index)
+		}
+		return list[i].tagged && !list[j].tagged
+	})
+
+	out := list[:0]
+	for i := 0; i < len(list); {
+		j := i + 1
+		for j < len(list) && list[j].name == list[i].name {
+			j++
+		}
+		if j == i+1 || len(list[i].index) < len(list[i+1].index) || list[i].tagged != list[i+1].tagged {
+			out = append(out, list[i])
+		}
+		i = j
+	}
+	list = out
+
+	fs := &structFields{
+		list:   list,
+		byName: make(map[string]int, len(list)),
+		inline: inline,
+		err:    tagErr,
+	}
+	for i := range list {
+		f := &list[i]
+		f.key = []byte(strconv.Itoa(len(f.name)) + ":" + f.name)
+		fs.byName[f.name] = i
+		if f.required || f.def != nil {
+			fs.checked++
+		}
+	}
+	return fs
+}
+
+// tagOptions is the comma-separated list of options following the name in
+// a struct field's bencode tag.
+type tagOptions string
+
+// parseTag splits a struct field's bencode tag into its name and options.
+func parseTag(tag string) (string, tagOptions) {
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i], tagOptions(tag[i+1:])
+	}
+	return tag, ""
+}
+
+// Contains reports whether opts includes the option name.
+func (opts tagOptions) Contains(name string) bool {
+	for s := string(opts); s != ""; {
+		var opt string
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			opt, s = s[:i], s[i+1:]
+		} else {
+			opt, s = s, ""
+		}
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value of the option name given as "name=value" in opts.
+// As options are separated by commas, the value cannot contain one.
+func (opts tagOptions) Get(name string) (string, bool) {
+	for s := string(opts); s != ""; {
+		var opt string
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			opt, s = s[:i], s[i+1:]
+		} else {
+			opt, s = s, ""
+		}
+		if strings.HasPrefix(opt, name) && len(opt) > len(name) && opt[len(name)] == '=' {
+			return opt[len(name)+1:], true
+		}
+	}
+	return "", false
+}
+
+// parseRules returns the constraints given by the "min=", "max=", "len="
+// and "nonempty" options in opts, or nil if there are none. It returns an
+// error naming the first option whose value is not an integer, or for
+// "len=", not a length.
+func parseRules(opts tagOptions) (*rules, error) {
+	r := rules{length: -1, nonEmpty: opts.Contains("nonempty")}
+	if v, ok := opts.Get("min"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.New("malformed option min=" + v)
+		}
+		r.min, r.hasMin = n, true
+	}
+	if v, ok := opts.Get("max"); ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, errors.New("malformed option max=" + v)
+		}
+		r.max, r.hasMax = n, true
+	}
+	if v, ok := opts.Get("len"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.New("malformed option len=" + v)
+		}
+		r.length = n
+	}
+	if !r.hasMin && !r.hasMax && r.length < 0 && !r.nonEmpty {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+// check returns the option that v violates, or "" if it satisfies them
+// all. A nil pointer satisfies all but nonempty; other pointers are checked
+// by the value they point to.
+func (r *rules) check(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if r.nonEmpty {
+				return "nonempty"
+			}
+			return ""
+		}
+		v = v.Elem()
+	}
+	if r.nonEmpty && isEmptyValue(v) {
+		return "nonempty"
+	}
+
+	var n int64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			if r.hasMax {
+				return "max=" + strconv.FormatInt(r.max, 10)
+			}
+			return ""
+		}
+		n = int64(v.Uint())
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		n = int64(v.Len())
+		if r.length >= 0 && n != int64(r.length) {
+			return "len=" + strconv.Itoa(r.length)
+		}
+	default:
+		return ""
+	}
+	if r.hasMin && n < r.min {
+		return "min=" + strconv.FormatInt(r.min, 10)
+	}
+	if r.hasMax && n > r.max {
+		return "max=" + strconv.FormatInt(r.max, 10)
+	}
+	return ""
+}
+
+// defaultValue returns the encoding of the "default=" option in opts for a
+// field of type t, or nil if there is none. The default is an integer if t,
+// or the type t points to, is of integer kind or is time.Time, 1 or 0 for
+// the bool values accepted by strconv.ParseBool, and a byte string for
+// types decoded from byte strings. It returns an error if the default is
+// not such a value, or if t is of another type.
+func defaultValue(t reflect.Type, opts tagOptions) ([]byte, error) {
+	def, ok := opts.Get("default")
+	if !ok {
+		return nil, nil
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	p := reflect.PointerTo(t)
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if !validInt([]byte(def)) {
+			return nil, errors.New("malformed option default=" + def)
+		}
+		return []byte("i" + def + "e"), nil
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(def)
+		if err != nil {
+			return nil, errors.New("malformed option default=" + def)
+		}
+		if b {
+			return []byte("i1e"), nil
+		}
+		return []byte("i0e"), nil
+
+	case reflect.Struct:
+		if t == timeType {
+			if !validInt([]byte(def)) {
+				return nil, errors.New("malformed option default=" + def)
+			}
+			return []byte("i" + def + "e"), nil
+		}
+	}
+
+	switch {
+	case t.Kind() == reflect.String, t.Kind() == reflect.Interface,
+		(t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8,
+		p.Implements(unmarshalerType), p.Implements(textUnmarshalerType), p.Implements(binaryUnmarshalerType):
+		return []byte(strconv.Itoa(len(def)) + ":" + def), nil
+	}
+	return nil, errors.New("option default=" + def + " on a field of type " + t.String())
+}
+
+// isScalar reports whether k is the kind of a bool or an integer, which the
+// string option applies to.
+func isScalar(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// isEmptyValue reports whether v is the zero value of a scalar type or of
+// time.Time, or an empty string, slice or map, as left out by the omitempty
+// option.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	case reflect.Struct:
+		return v.Type() == timeType && v.Interface().(time.Time).IsZero()
+	}
+	return false
+}
+
+// isNil reports whether v is a nil pointer or interface.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isNilEntry reports whether v, a map value or struct field, is nil as a
+// dictionary entry's value: a nil pointer or interface, or an interface
+// holding a nil pointer.
+func isNilEntry(v reflect.Value) bool {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	return isNil(v)
+}
+
+// lookup returns the field with the given key, or nil if there is none. If
+// fold is set, keys are matched case-insensitively when there is no exact
+// match.
+func (fs *structFields) lookup(key []byte, fold bool) *field {
+	if i, ok := fs.byName[string(key)]; ok {
+		return &fs.list[i]
+	}
+	if fold {
+		for i := range fs.list {
+			if strings.EqualFold(fs.list[i].name, string(key)) {
+				return &fs.list[i]
+			}
+		}
+	}
+	return nil
+}
+
+// marshalStruct writes the struct v as a dictionary of its fields, along
+// with the entries of its inline map.
+func (e *encodeState) marshalStruct(v reflect.Value) error {
+	fields := cachedFields(v.Type(), e.keys)
+
+	var m reflect.Value
+	var keys []reflect.Value
+	if fields.inline != nil {
+		if mv, ok := fieldByIndex(v, fields.inline); ok {
+			m, keys = mv, sortedMapKeys(mv)
+		}
+	}
+	// marshalEntries writes the inline entries with keys before name, or
+	// all remaining ones if last is set. Entries with the key of a field
+	// are left out, as the field takes precedence.
+	marshalEntries := func(name string, last bool) error {
+		for len(keys) > 0 && (last || keys[0].String() < name) {
+			k := keys[0]
+			keys = keys[1:]
+			if _, ok := fields.byName[k.String()]; ok {
+				continue
+			}
+			val := m.MapIndex(k)
+			if isNilEntry(val) {
+				omit, err := e.omitNil(v.Type(), k.String())
+				if err != nil {
+					return err
+				}
+				if omit {
+					continue
+				}
+			}
+			e.marshalString(k.String())
+			if err := e.marshalAt(k.String(), val.Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	e.Write([]byte{'d'})
+	for _, f := range fields.list {
+		if err := marshalEntries(f.name, false); err != nil {
+			return err
+		}
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if isNilEntry(fv) {
+			omit, err := e.omitNil(v.Type(), f.name)
+			if err != nil {
+				return err
+			}
+			if omit {
+				continue
+			}
+		}
+		e.Write(f.key)
+		if f.milli {
+			e.marshalInt(fv.Interface().(time.Time).UnixMilli())
+			continue
+		}
+		if f.asString {
+			switch fv.Kind() {
+			case reflect.Bool:
+				if fv.Bool() {
+					e.marshalString("1")
+				} else {
+					e.marshalString("0")
+				}
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				e.marshalString(strconv.FormatInt(fv.Int(), 10))
+			default:
+				e.marshalString(strconv.FormatUint(fv.Uint(), 10))
+			}
+			continue
+		}
+		err := e.marshalAt(f.name, fv.Interface())
+		if err != nil {
+			return err
+		}
+	}
+	if err := marshalEntries("", true); err != nil {
+		return err
+	}
+	e.Write([]byte{'e'})
+	return nil
+}
+
+// sortedMapKeys returns the keys of m, a map with keys of string kind, in
+// sorted order.
+func sortedMapKeys(m reflect.Value) []reflect.Value {
+	keys := m.MapKeys()
+	sortMapKeys(keys)
+	return keys
+}
+
+// sortMapKeys sorts keys, the keys of a map with keys of string kind.
+func sortMapKeys(keys []reflect.Value) {
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+}
+
+// fieldByIndex returns the field of the struct v with the given index
+// sequence. It reports false if the field is reached through a nil embedded
+// pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// fieldByIndexAlloc is like fieldByIndex, but allocates nil embedded
+// pointers along the way. It reports false if one cannot be set.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// readStruct consumes a dictionary and stores its entries in the fields of
+// the struct v. Entries without a corresponding field are stored in the
+// inline map, if there is one, and skipped otherwise. A missing entry for a
+// required field is saved as a *RequiredFieldError, and a missing entry for
+// a field with a default is decoded from the default instead.
+func (d *decodeState) readStruct(v reflect.Value) error {
+	fields := cachedFields(v.Type(), d.keys)
+	if fields.err != nil {
+		d.saveError(fields.err)
+		return d.skip()
+	}
+	var seen map[string]bool
+	if fields.checked > 0 {
+		seen = make(map[string]bool, fields.checked)
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+	d.off++ // 'd'
+	for {
+		ok, err := d.more()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if len(seen) < fields.checked {
+				d.missingFields(v, fields, seen)
+			}
+			return nil
+		}
+
+		start := d.offset()
+		key, err := d.readKeyBytes()
+		if err != nil {
+			return err
+		}
+		f := fields.lookup(key, d.foldKeys)
+		if f == nil && fields.inline != nil {
+			if m, ok := fieldByIndexAlloc(v, fields.inline); ok {
+				if m.IsNil() {
+					m.Set(reflect.MakeMap(m.Type()))
+				}
+				if err := d.readMapValue(m, string(key)); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if f == nil {
+			if d.disallowUnknown {
+				d.saveError(&UnknownFieldError{Struct: v.Type().Name(), Field: string(key), Offset: start})
+			}
+			if err := d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fv, ok := fieldByIndexAlloc(v, f.index)
+		if !ok {
+			// An unexported embedded struct pointer that is nil cannot be
+			// allocated.
+			if err := d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.required || f.def != nil {
+			seen[f.name] = true
+		}
+		prev := d.savedError
+		start = d.offset()
+		if err := d.decodeField(f, fv); err != nil {
+			return err
+		}
+		if f.rules != nil && d.savedError == prev {
+			if rule := f.rules.check(fv); rule != "" {
+				d.saveError(&ValidationError{Rule: rule, Offset: start})
+			}
+		}
+		if prev == nil {
+			d.addErrorContext(v.Type(), f.name)
+		}
+	}
+}
+
+// missingFields handles the required fields and fields with a default of
+// the struct v that are not in seen. Defaults are decoded into their fields,
+// and a *RequiredFieldError is saved for the first missing required field.
+func (d *decodeState) missingFields(v reflect.Value, fields *structFields, seen map[string]bool) {
+	for _, f := range fields.list {
+		if seen[f.name] {
+			continue
+		}
+		if f.required {
+			d.saveError(&RequiredFieldError{Struct: v.Type().Name(), Field: f.name})
+		}
+		if f.def == nil {
+			continue
+		}
+		fv, ok := fieldByIndexAlloc(v, f.index)
+		if !ok {
+			continue
+		}
+		def := d.subState(f.def)
+		if err := def.unmarshal(fv.Addr().Interface()); err != nil {
+			d.saveError(err)
+		}
+	}
+}
+
+// decodeField consumes the next value and stores it in fv, the struct field
+// described by f, passing it through the decode hook if there is one.
+func (d *decodeState) decodeField(f *field, fv reflect.Value) error {
+	if f.milli {
+		c, err := d.peekValue()
+		if err != nil {
+			return err
+		}
+		if c != 'i' {
+			return d.mismatch(c, timeType)
+		}
+		n, err := d.readInt(64, timeType)
+		fv.Set(reflect.ValueOf(time.UnixMilli(n)))
+		return err
+	}
+	if f.asString && fv.Kind() != reflect.Bool {
+		c, err := d.peekValue()
+		if err != nil {
+			return err
+		}
+		if isDigit(c) {
+			return d.readIntString(fv)
+		}
+	}
+	if d.hook == nil {
+		return d.decode(fv.Addr().Interface())
+	}
+
+	data, err := d.value()
+	if err != nil {
+		return err
+	}
+	out, err := d.hook(fv.Type(), data)
+	if err != nil {
+		d.saveError(err)
+		return nil
+	}
+	if out != nil && reflect.TypeOf(out).AssignableTo(fv.Type()) {
+		fv.Set(reflect.ValueOf(out))
+		return nil
+	}
+
+	var buf bytes.Buffer
+	e := encodeState{w: &buf, keys: d.keys}
+	if err := e.marshal(out); err != nil {
+		d.saveError(err)
+		return nil
+	}
+	sub := d.subState(buf.Bytes())
+	if err := sub.unmarshal(fv.Addr().Interface()); err != nil {
+		d.saveError(err)
+	}
+	return nil
+}
+
+// readIntString consumes a byte string holding a decimal integer and stores
+// it in v, a value of integer kind. Strings that are not integers or do not
+// fit in v are recorded as an error.
+func (d *decodeState) readIntString(v reflect.Value) error {
+	start := d.offset()
+	b, err := d.readString()
+	if err != nil {
+		return err
+	}
+
+	ok := validInt(b)
+	if ok {
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var n int64
+			n, ok = parseInt(b)
+			if ok = ok && !v.OverflowInt(n); ok {
+				v.SetInt(n)
+			}
+		default:
+			if b[0] == '-' {
+				ok = false
+				break
+			}
+			var n uint64
+			n, ok = parseUint(b)
+			if ok = ok && !v.OverflowUint(n); ok {
+				v.SetUint(n)
+			}
+		}
+	}
+	if !ok {
+		d.saveError(&UnmarshalTypeError{
+			Value:  "string " + strconv.Quote(string(b)),
+			Type:   v.Type(),
+			Offset: start,
+		})
+	}
+	return nil
+}
+
+// subState returns a decodeState with d's configuration that reads data,
+// for decoding values that do not come from the input.
+func (d *decodeState) subState(data []byte) decodeState {
+	return decodeState{
+		data:            data,
+		mark:            -1,
+		useBytes:        d.useBytes,
+		overflow:        d.overflow,
+		foldKeys:        d.foldKeys,
+		disallowUnknown: d.disallowUnknown,
+		keys:            d.keys,
+		hook:            d.hook,
+	}
+}
+
+// addErrorContext records in a saved *UnmarshalTypeError,
+// *RequiredFieldError, *UnknownFieldError or *ValidationError that it
+// occurred in the field with the given key of a
+// struct of type t.
+func (d *decodeState) addErrorContext(t reflect.Type, key string) {
+	var structName, field *string
+	switch err := d.savedError.(type) {
+	case *UnmarshalTypeError:
+		structName, field = &err.Struct, &err.Field
+	case *RequiredFieldError:
+		structName, field = &err.Struct, &err.Field
+	case *UnknownFieldError:
+		structName, field = &err.Struct, &err.Field
+	case *ValidationError:
+		structName, field = &err.Struct, &err.Field
+	default:
+		return
+	}
+	if *structName == "" {
+		*structName = t.Name()
+	}
+	if *field != "" {
+		key += "." + *field
+	}
+	*field = key
+}
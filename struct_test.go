@@ -0,0 +1,842 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testFile struct {
+	Length int64
+	Path   []string
+}
+
+type testInfo struct {
+	Name   string
+	Pieces []byte
+	File   testFile
+	Extra  map[string]interface{}
+	secret string
+}
+
+type testTorrent struct {
+	Announce string
+	Info     testInfo
+	Raw      RawBytes
+}
+
+const testTorrentEncoding = "d8:Announce3:url4:Infod5:Extrad1:ai1ee4:Filed6:Lengthi42e4:Pathl1:a1:bee4:Name4:spam6:Pieces3:abce3:Rawi1ee"
+
+func TestMarshalStruct(t *testing.T) {
+	torrent := testTorrent{
+		Announce: "url",
+		Info: testInfo{
+			Name:   "spam",
+			Pieces: []byte("abc"),
+			File:   testFile{Length: 42, Path: []string{"a", "b"}},
+			Extra:  map[string]interface{}{"a": 1},
+			secret: "hidden",
+		},
+		Raw: RawBytes("i1e"),
+	}
+
+	for _, v := range []interface{}{torrent, &torrent} {
+		got, err := Marshal(v)
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != testTorrentEncoding {
+			t.Errorf("\ngot:      %s\nexpected: %s", got, testTorrentEncoding)
+		}
+	}
+
+	if _, err := Marshal(struct{ F float64 }{1}); err == nil {
+		t.Error("expected error for unsupported field type")
+	}
+	if _, err := Marshal((*testInfo)(nil)); err == nil {
+		t.Error("expected error for nil pointer")
+	}
+	if _, err := Marshal(nil); err == nil {
+		t.Error("expected error for nil")
+	}
+}
+
+func TestUnmarshalStruct(t *testing.T) {
+	var got testTorrent
+	input := strings.Replace(testTorrentEncoding, "4:Name", "7:Unknownli1ee4:Name", 1)
+	if err := Unmarshal([]byte(input), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := testTorrent{
+		Announce: "url",
+		Info: testInfo{
+			Name:   "spam",
+			Pieces: []byte("abc"),
+			File:   testFile{Length: 42, Path: []string{"a", "b"}},
+			Extra:  map[string]interface{}{"a": int64(1)},
+		},
+		Raw: RawBytes("i1e"),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", got, expected)
+	}
+}
+
+func TestUnmarshalStructErrors(t *testing.T) {
+	var got testTorrent
+	err := Unmarshal([]byte("d8:Announcei1e4:Infod4:Filed6:Length1:xeee"), &got)
+	terr, ok := err.(*UnmarshalTypeError)
+	if !ok {
+		t.Fatalf("expected *UnmarshalTypeError, got %#v", err)
+	}
+	if terr.Struct != "testTorrent" || terr.Field != "Announce" {
+		t.Errorf("unexpected context %s.%s", terr.Struct, terr.Field)
+	}
+
+	err = Unmarshal([]byte("d4:Infod4:Filed6:Length1:xeee"), &got)
+	if terr, ok := err.(*UnmarshalTypeError); !ok || terr.Struct != "testFile" || terr.Field != "Info.File.Length" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := Unmarshal([]byte("le"), &got); err == nil {
+		t.Error("expected error decoding list into struct")
+	}
+}
+
+func TestDecoderStructCaseInsensitive(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d8:ANNOUNCE3:urle"))
+	dec.CaseInsensitiveKeys()
+
+	var got testTorrent
+	if err := dec.Decode(&got); err != nil {
+		t.Error(err)
+	} else if got.Announce != "url" {
+		t.Errorf("got %q, expected %q", got.Announce, "url")
+	}
+}
+
+type testTagged struct {
+	AnnounceList []string `bencode:"announce-list"`
+	CreatedBy    string   `bencode:"created by,"`
+	Comment      string   `bencode:",unknown"`
+}
+
+func TestStructTags(t *testing.T) {
+	v := testTagged{AnnounceList: []string{"a"}, CreatedBy: "me", Comment: "hi"}
+	expected := "d7:Comment2:hi13:announce-listl1:ae10:created by2:mee"
+
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	var decoded testTagged
+	if err := Unmarshal([]byte(expected), &decoded); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(decoded, v) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", decoded, v)
+	}
+}
+
+type testResponse struct {
+	Interval       int64             `bencode:"interval"`
+	MinInterval    int64             `bencode:"min interval,omitempty"`
+	WarningMessage string            `bencode:"warning message,omitempty"`
+	Peers          []string          `bencode:"peers,omitempty"`
+	Extra          map[string]string `bencode:",omitempty"`
+}
+
+func TestStructOmitEmpty(t *testing.T) {
+	tests := []struct {
+		v        testResponse
+		expected string
+	}{
+		{testResponse{}, "d8:intervali0ee"},
+		{testResponse{Interval: 1800, MinInterval: 900}, "d8:intervali1800e12:min intervali900ee"},
+		{testResponse{WarningMessage: "slow down", Peers: []string{}}, "d8:intervali0e15:warning message9:slow downe"},
+	}
+	for _, test := range tests {
+		got, err := Marshal(test.v)
+		if err != nil {
+			t.Error(err)
+		} else if string(got) != test.expected {
+			t.Errorf("\ngot:      %s\nexpected: %s", got, test.expected)
+		}
+	}
+}
+
+type testIgnored struct {
+	Name  string `bencode:"name"`
+	Cache []byte `bencode:"-"`
+	Dash  int64  `bencode:"-,"`
+}
+
+func TestStructIgnoredFields(t *testing.T) {
+	got, err := Marshal(testIgnored{Name: "a", Cache: []byte("x"), Dash: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d1:-i1e4:name1:ae"; string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	var v testIgnored
+	err = Unmarshal([]byte("d1:-i2e5:Cache1:x4:name1:be"), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (testIgnored{Name: "b", Dash: 2}); !reflect.DeepEqual(v, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", v, expected)
+	}
+}
+
+type testResponseBase struct {
+	Interval    int64  `bencode:"interval"`
+	FailReason  string `bencode:"failure reason,omitempty"`
+	TrackerID   string `bencode:"tracker id,omitempty"`
+	Conflicting int64
+}
+
+type testScrapeBase struct {
+	Conflicting int64
+	Complete    int64 `bencode:"complete"`
+}
+
+type testAnnounce struct {
+	testResponseBase
+	*testScrapeBase
+	TrackerID string `bencode:"tracker id"`
+	Peers     string `bencode:"peers"`
+}
+
+func TestStructEmbedded(t *testing.T) {
+	v := testAnnounce{
+		testResponseBase: testResponseBase{Interval: 1800, TrackerID: "shadowed", Conflicting: 1},
+		testScrapeBase:   &testScrapeBase{Complete: 3},
+		TrackerID:        "abc",
+		Peers:            "",
+	}
+	expected := "d8:completei3e8:intervali1800e5:peers0:10:tracker id3:abce"
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	v.testScrapeBase = nil
+	expected = "d8:intervali1800e5:peers0:10:tracker id3:abce"
+	got, err = Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+}
+
+type TestScrape struct {
+	Complete int64 `bencode:"complete"`
+}
+
+type testScrapeResponse struct {
+	*TestScrape
+	Interval int64 `bencode:"interval"`
+}
+
+func TestUnmarshalStructEmbedded(t *testing.T) {
+	var v testScrapeResponse
+	err := Unmarshal([]byte("d8:completei3e8:intervali1800ee"), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := testScrapeResponse{TestScrape: &TestScrape{Complete: 3}, Interval: 1800}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", v, expected)
+	}
+
+	// A nil pointer to an unexported embedded struct cannot be allocated.
+	var a testAnnounce
+	err = Unmarshal([]byte("d8:completei3e8:intervali1800e10:tracker id3:abce"), &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Interval != 1800 || a.TrackerID != "abc" || a.testScrapeBase != nil {
+		t.Errorf("unexpected result %#v", a)
+	}
+}
+
+type testInlineBase struct {
+	Interval int64 `bencode:"interval"`
+}
+
+type testInline struct {
+	Base       testInlineBase `bencode:",inline"`
+	Peers      string         `bencode:"peers"`
+	Extensions Dict           `bencode:",inline"`
+}
+
+func TestStructInline(t *testing.T) {
+	v := testInline{
+		Base:  testInlineBase{Interval: 1800},
+		Peers: "",
+		Extensions: Dict{
+			"complete": int64(3),
+			"peers":    "shadowed",
+			"zz":       "last",
+		},
+	}
+	expected := "d8:completei3e8:intervali1800e5:peers0:2:zz4:laste"
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	var d testInline
+	err = Unmarshal([]byte(expected), &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Extensions = Dict{"complete": int64(3), "zz": "last"}
+	if !reflect.DeepEqual(d, v) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", d, v)
+	}
+}
+
+type testRequiredInfo struct {
+	Name   string `bencode:"name,required"`
+	Length int64  `bencode:"length"`
+}
+
+type testRequired struct {
+	Announce string           `bencode:"announce"`
+	Info     testRequiredInfo `bencode:"info,required"`
+}
+
+func TestStructRequired(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected error
+	}{
+		{"d4:infod4:name1:aee", nil},
+		{"d8:announce1:ae", &RequiredFieldError{Struct: "testRequired", Field: "info"}},
+		{"d4:infod6:lengthi1eee", &RequiredFieldError{Struct: "testRequiredInfo", Field: "info.name"}},
+	}
+	for _, test := range tests {
+		var v testRequired
+		err := Unmarshal([]byte(test.data), &v)
+		if !reflect.DeepEqual(err, test.expected) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", err, test.expected)
+		}
+	}
+
+	err := Unmarshal([]byte("d8:announce1:ae"), &testRequired{})
+	expected := "bencode: missing required key for Go struct field testRequired.info"
+	if err == nil || err.Error() != expected {
+		t.Errorf("\ngot:      %v\nexpected: %s", err, expected)
+	}
+}
+
+type testRest struct {
+	Announce string              `bencode:"announce"`
+	Unknown  map[string]RawBytes `bencode:",rest"`
+}
+
+func TestStructRestRoundTrip(t *testing.T) {
+	data := "d8:announce3:udp7:commentd1:xli1ei2eee10:created by2:mee"
+	var v testRest
+	if err := Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	expected := testRest{
+		Announce: "udp",
+		Unknown: map[string]RawBytes{
+			"comment":    RawBytes("d1:xli1ei2eee"),
+			"created by": RawBytes("2:me"),
+		},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", v, expected)
+	}
+
+	v.Announce = "http"
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d8:announce4:http7:commentd1:xli1ei2eee10:created by2:mee"; string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+}
+
+func TestDecoderDisallowUnknownFields(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected error
+	}{
+		{"d6:Lengthi1e4:Pathl1:aee", nil},
+		{"d6:Lengthi1e6:Pieces0:e", &UnknownFieldError{Struct: "testFile", Field: "Pieces", Offset: 12}},
+	}
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(test.data))
+		dec.DisallowUnknownFields()
+		var v testFile
+		err := dec.Decode(&v)
+		if !reflect.DeepEqual(err, test.expected) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", err, test.expected)
+		}
+	}
+
+	dec := NewDecoder(strings.NewReader("d4:infod4:name1:a1:xi0eee"))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&testRequired{})
+	expected := `bencode: unknown key "info.x" for Go struct testRequiredInfo`
+	if err == nil || err.Error() != expected {
+		t.Errorf("\ngot:      %v\nexpected: %s", err, expected)
+	}
+
+	// Entries stored in an inline map are not unknown.
+	dec = NewDecoder(strings.NewReader("d8:intervali1e1:xi0ee"))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&testInline{}); err != nil {
+		t.Error(err)
+	}
+}
+
+// spaced maps a Go field name such as MinInterval to "min interval".
+func spaced(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if 'A' <= r && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type testKeyFunc struct {
+	Interval    int64
+	MinInterval int64
+	TrackerID   string `bencode:"tracker id"`
+}
+
+func TestKeyFunc(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetKeyFunc(spaced)
+	v := testKeyFunc{Interval: 1800, MinInterval: 900, TrackerID: "abc"}
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	expected := "d8:intervali1800e12:min intervali900e10:tracker id3:abce"
+	if buf.String() != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", buf.String(), expected)
+	}
+
+	var got testKeyFunc
+	dec := NewDecoder(strings.NewReader(expected))
+	dec.SetKeyFunc(spaced)
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got != v {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", got, v)
+	}
+
+	// Without the function, the same type uses the field names.
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d8:Intervali1800e11:MinIntervali900e10:tracker id3:abce"; string(b) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", b, expected)
+	}
+}
+
+type testOptional struct {
+	Interval    int64           `bencode:"interval"`
+	MinInterval *int64          `bencode:"min interval"`
+	TrackerID   *string         `bencode:"tracker id"`
+	Info        *testInlineBase `bencode:"info"`
+}
+
+func TestStructPointerFields(t *testing.T) {
+	var v testOptional
+	if err := Unmarshal([]byte("d4:infod8:intervali1ee8:intervali0e12:min intervali0ee"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.MinInterval == nil || *v.MinInterval != 0 || v.TrackerID != nil || v.Info == nil || v.Info.Interval != 1 {
+		t.Errorf("unexpected result %#v", v)
+	}
+
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d4:infod8:intervali1ee8:intervali0e12:min intervali0ee"; string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetNilPolicy(NilError)
+	err = enc.Encode(v)
+	expected := &NilValueError{Type: reflect.TypeOf(v), Key: "tracker id"}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", err, expected)
+	}
+}
+
+type testDefaults struct {
+	Interval    int64      `bencode:"interval,default=1800"`
+	MinInterval *uint16    `bencode:"min interval,default=900"`
+	Peers       string     `bencode:"peers,default=none"`
+	Complete    int64      `bencode:"complete"`
+	Compact     bool       `bencode:"compact,default=true"`
+	NoPeerID    *bool      `bencode:"no_peer_id,default=false"`
+	Tracker     netip.Addr `bencode:"tracker,default=10.0.0.1"`
+}
+
+func TestStructDefaults(t *testing.T) {
+	var v testDefaults
+	if err := Unmarshal([]byte("d8:intervali60ee"), &v); err != nil {
+		t.Fatal(err)
+	}
+	expected := testDefaults{
+		Interval:    60,
+		MinInterval: new(uint16),
+		Peers:       "none",
+		Compact:     true,
+		NoPeerID:    new(bool),
+		Tracker:     netip.MustParseAddr("10.0.0.1"),
+	}
+	*expected.MinInterval = 900
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", v, expected)
+	}
+}
+
+type testBadIntDefault struct {
+	Interval int64 `bencode:"interval,default=30m"`
+}
+
+type testBadBoolDefault struct {
+	Compact bool `bencode:"compact,default=yes"`
+}
+
+type testFloatDefault struct {
+	Ratio float64 `bencode:"ratio,default=0.5"`
+}
+
+type testSliceDefault struct {
+	Ports []int `bencode:"ports,default=1"`
+}
+
+func TestStructBadDefaults(t *testing.T) {
+	tests := []struct {
+		v        interface{}
+		expected string
+	}{
+		{&testBadIntDefault{}, "bencode: malformed option default=30m in the tag of field bencode.testBadIntDefault.Interval"},
+		{&testBadBoolDefault{}, "bencode: malformed option default=yes in the tag of field bencode.testBadBoolDefault.Compact"},
+		{&testFloatDefault{}, "bencode: option default=0.5 on a field of type float64 in the tag of field bencode.testFloatDefault.Ratio"},
+		{&testSliceDefault{}, "bencode: option default=1 on a field of type []int in the tag of field bencode.testSliceDefault.Ports"},
+	}
+	for _, test := range tests {
+		err := Unmarshal([]byte("de"), test.v)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("\ngot:      %v\nexpected: %s", err, test.expected)
+		}
+	}
+}
+
+func TestStructBadDefaultMarshal(t *testing.T) {
+	b, err := Marshal(testSliceDefault{Ports: []int{6881}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d5:portsli6881eee"; string(b) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", b, expected)
+	}
+}
+
+type testHooked struct {
+	Interval int64            `bencode:"interval"`
+	Peers    []netip.AddrPort `bencode:"peers"`
+	Tracker  testInlineBase   `bencode:"tracker"`
+}
+
+var addrPortsType = reflect.TypeOf([]netip.AddrPort(nil))
+
+// compactPeers parses compact IPv4 peer strings into []netip.AddrPort.
+func compactPeers(to reflect.Type, data interface{}) (interface{}, error) {
+	s, ok := data.(string)
+	if to != addrPortsType || !ok {
+		return data, nil
+	}
+	if len(s)%6 != 0 {
+		return nil, errors.New("bad compact peers")
+	}
+	var peers []netip.AddrPort
+	for ; len(s) > 0; s = s[6:] {
+		addr := netip.AddrFrom4([4]byte{s[0], s[1], s[2], s[3]})
+		peers = append(peers, netip.AddrPortFrom(addr, uint16(s[4])<<8|uint16(s[5])))
+	}
+	return peers, nil
+}
+
+func TestDecoderDecodeHook(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d8:intervali2e5:peers12:\xc0\x00\x02\x01\x1a\xe1\xc0\x00\x02\x02\x1a\xe17:trackerd8:intervali3eee"))
+	dec.SetDecodeHook(compactPeers)
+	var v testHooked
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	expected := testHooked{
+		Interval: 2,
+		Peers: []netip.AddrPort{
+			netip.MustParseAddrPort("192.0.2.1:6881"),
+			netip.MustParseAddrPort("192.0.2.2:6881"),
+		},
+		Tracker: testInlineBase{Interval: 3},
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", v, expected)
+	}
+
+	dec = NewDecoder(strings.NewReader("d8:intervali2e5:peers1:xe"))
+	dec.SetDecodeHook(compactPeers)
+	if err := dec.Decode(&testHooked{}); err == nil || err.Error() != "bad compact peers" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+type testTimes struct {
+	CreationDate time.Time `bencode:"creation date,omitempty"`
+	Updated      time.Time `bencode:"updated,unixmilli"`
+}
+
+func TestStructTimes(t *testing.T) {
+	v := testTimes{
+		CreationDate: time.Unix(1500000000, 0),
+		Updated:      time.UnixMilli(1500000000123),
+	}
+	expected := "d13:creation datei1500000000e7:updatedi1500000000123ee"
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	var d testTimes
+	if err := Unmarshal([]byte(expected), &d); err != nil {
+		t.Fatal(err)
+	}
+	if !d.CreationDate.Equal(v.CreationDate) || !d.Updated.Equal(v.Updated) {
+		t.Errorf("\ngot:      %v\nexpected: %v", d, v)
+	}
+
+	got, err = Marshal(testTimes{Updated: v.Updated})
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := "d7:updatedi1500000000123ee"; string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+}
+
+type testFlags struct {
+	Private bool `bencode:"private"`
+	Seed    bool `bencode:"seed,string"`
+}
+
+func TestStructBools(t *testing.T) {
+	v := testFlags{Private: true, Seed: true}
+	expected := "d7:privatei1e4:seed1:1e"
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	var d testFlags
+	if err := Unmarshal([]byte(expected), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d != v {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", d, v)
+	}
+}
+
+type testStringInts struct {
+	Port   uint16 `bencode:"port,string"`
+	Offset int64  `bencode:"offset,string"`
+	Left   int64  `bencode:"left"`
+}
+
+func TestStructStringInts(t *testing.T) {
+	v := testStringInts{Port: 6881, Offset: -5, Left: 7}
+	expected := "d4:lefti7e6:offset2:-54:port4:6881e"
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(got) != expected {
+		t.Errorf("\ngot:      %s\nexpected: %s", got, expected)
+	}
+
+	var d testStringInts
+	if err := Unmarshal([]byte(expected), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d != v {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", d, v)
+	}
+
+	// Integers are accepted as well.
+	d = testStringInts{}
+	if err := Unmarshal([]byte("d4:porti6881ee"), &d); err != nil || d.Port != 6881 {
+		t.Errorf("unexpected result %#v, %v", d, err)
+	}
+
+	for _, data := range []string{"d4:port5:65536e", "d4:port2:-1e", "d6:offset2:01e", "d6:offset1:xe"} {
+		err := Unmarshal([]byte(data), &d)
+		if _, ok := err.(*UnmarshalTypeError); !ok {
+			t.Errorf("%s: unexpected error %v", data, err)
+		}
+	}
+}
+
+type testValidatedInfo struct {
+	PieceLength int64    `bencode:"piece length,min=16384,max=16777216"`
+	Pieces      []byte   `bencode:"pieces,nonempty"`
+	Root        string   `bencode:"root,len=4"`
+	Files       []string `bencode:"files,max=2"`
+	Private     *uint8   `bencode:"private,max=1"`
+}
+
+type testValidated struct {
+	Info testValidatedInfo `bencode:"info"`
+}
+
+func TestStructValidation(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected error
+	}{
+		{"d4:infod12:piece lengthi16384e6:pieces1:x4:root4:abcdee", nil},
+		{"d4:infod12:piece lengthi1024e6:pieces1:xee", &ValidationError{Rule: "min=16384", Struct: "testValidatedInfo", Field: "info.piece length", Offset: 23}},
+		{"d4:infod12:piece lengthi33554432eee", &ValidationError{Rule: "max=16777216", Struct: "testValidatedInfo", Field: "info.piece length", Offset: 23}},
+		{"d4:infod6:pieces0:ee", &ValidationError{Rule: "nonempty", Struct: "testValidatedInfo", Field: "info.pieces", Offset: 16}},
+		{"d4:infod4:root3:abcee", &ValidationError{Rule: "len=4", Struct: "testValidatedInfo", Field: "info.root", Offset: 14}},
+		{"d4:infod5:filesl1:a1:b1:ceee", &ValidationError{Rule: "max=2", Struct: "testValidatedInfo", Field: "info.files", Offset: 15}},
+		{"d4:infod7:privatei2eee", &ValidationError{Rule: "max=1", Struct: "testValidatedInfo", Field: "info.private", Offset: 17}},
+	}
+	for _, test := range tests {
+		var v testValidated
+		err := Unmarshal([]byte(test.data), &v)
+		if !reflect.DeepEqual(err, test.expected) {
+			t.Errorf("\ngot:      %#v\nexpected: %#v", err, test.expected)
+		}
+	}
+
+	err := Unmarshal([]byte("d4:infod6:pieces0:ee"), &testValidated{})
+	expected := "bencode: value of Go struct field testValidatedInfo.info.pieces violates nonempty"
+	if err == nil || err.Error() != expected {
+		t.Errorf("\ngot:      %v\nexpected: %s", err, expected)
+	}
+}
+
+type testBadMin struct {
+	PieceLength int64 `bencode:"piece length,min=16k"`
+}
+
+type testBadMax struct {
+	Files []string `bencode:"files,max="`
+}
+
+type testBadLen struct {
+	Root string `bencode:"root,len=x"`
+}
+
+func TestStructMalformedRules(t *testing.T) {
+	tests := []struct {
+		v        interface{}
+		expected string
+	}{
+		{&testBadMin{}, "bencode: malformed option min=16k in the tag of field bencode.testBadMin.PieceLength"},
+		{&testBadMax{}, "bencode: malformed option max= in the tag of field bencode.testBadMax.Files"},
+		{&testBadLen{}, "bencode: malformed option len=x in the tag of field bencode.testBadLen.Root"},
+	}
+	for _, test := range tests {
+		err := Unmarshal([]byte("d3:fooi1ee"), test.v)
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("\ngot:      %v\nexpected: %s", err, test.expected)
+		}
+		if _, err := Marshal(test.v); err != nil {
+			t.Errorf("Marshal(%T): %v", test.v, err)
+		}
+	}
+}
+
+func TestTagOptions(t *testing.T) {
+	_, opts := parseTag("name,omitempty,other,default=a=b")
+	if !opts.Contains("omitempty") || !opts.Contains("other") || opts.Contains("omit") {
+		t.Errorf("unexpected options %q", opts)
+	}
+	if v, ok := opts.Get("default"); !ok || v != "a=b" {
+		t.Errorf("unexpected default %q", v)
+	}
+	if _, ok := opts.Get("other"); ok {
+		t.Error("unexpected value for option without one")
+	}
+}
+
+func TestStructFieldCache(t *testing.T) {
+	if _, err := Marshal(testFile{}); err != nil {
+		t.Fatal(err)
+	}
+	f, ok := fieldCache.Load(reflect.TypeOf(testFile{}))
+	if !ok {
+		t.Fatal("expected fields of testFile to be cached")
+	}
+	for _, f := range f.(*structFields).list {
+		if expected := strconv.Itoa(len(f.name)) + ":" + f.name; string(f.key) != expected {
+			t.Errorf("\ngot:      %s\nexpected: %s", f.key, expected)
+		}
+	}
+	if cachedFields(reflect.TypeOf(testFile{}), nil) != f {
+		t.Error("expected cached fields to be reused")
+	}
+}
+
+func BenchmarkMarshalStruct(b *testing.B) {
+	v := testFile{Length: 42, Path: []string{"a", "b"}}
+	for i := 0; i < b.N; i++ {
+		Marshal(v)
+	}
+}
+
+func BenchmarkUnmarshalStruct(b *testing.B) {
+	data := []byte("d6:Lengthi42e4:Pathl1:a1:bee")
+	for i := 0; i < b.N; i++ {
+		var v testFile
+		Unmarshal(data, &v)
+	}
+}
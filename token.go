@@ -0,0 +1,260 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import "strconv"
+
+// A Token holds a value of one of these types:
+//
+//	Delim, for the start and end of dictionaries and lists
+//	int64, for integers, or *big.Int under IntOverflowBigInt
+//	[]byte, for byte strings, including dictionary keys
+type Token interface{}
+
+// A Delim is a Token that starts or ends a dictionary or a list.
+type Delim int
+
+const (
+	DictStart Delim = iota
+	DictEnd
+	ListStart
+	ListEnd
+)
+
+// String returns the bencoding of the delimiter.
+func (d Delim) String() string {
+	switch d {
+	case DictStart:
+		return "d"
+	case ListStart:
+		return "l"
+	default:
+		return "e"
+	}
+}
+
+// A Kind is the kind of a bencoded value.
+type Kind int
+
+const (
+	InvalidKind Kind = iota
+	IntKind
+	StringKind
+	ListKind
+	DictKind
+)
+
+// String returns the name of the kind, as used in error messages.
+func (k Kind) String() string {
+	switch k {
+	case IntKind:
+		return "integer"
+	case StringKind:
+		return "string"
+	case ListKind:
+		return "list"
+	case DictKind:
+		return "dict"
+	default:
+		return "invalid"
+	}
+}
+
+// kindOf returns the kind of the value that starts with c, which has been
+// checked by peekValue.
+func kindOf(c byte) Kind {
+	switch c {
+	case 'i':
+		return IntKind
+	case 'l':
+		return ListKind
+	case 'd':
+		return DictKind
+	default:
+		return StringKind
+	}
+}
+
+// PeekKind returns the kind of the next value in the input without
+// consuming it, so that a field that may hold values of several kinds can
+// be decoded into a target of the right type:
+//
+//	kind, err := dec.PeekKind()
+//	if err != nil {
+//		return err
+//	}
+//	if kind == bencode.DictKind {
+//		return dec.Decode(&errorReply)
+//	}
+//	return dec.Decode(&nodes)
+//
+// Like Decode, it returns io.EOF if the input is exhausted before the value
+// begins. At the end of a dictionary or list begun by Token, where the next
+// token is not a value, PeekKind returns a *SyntaxError; More reports this
+// case.
+func (dec *Decoder) PeekKind() (Kind, error) {
+	if err := dec.buffer(); err != nil {
+		return InvalidKind, err
+	}
+	c, err := dec.d.peekValue()
+	if err != nil {
+		return InvalidKind, err
+	}
+	return kindOf(c), nil
+}
+
+// A tokenFrame tracks a dictionary or list whose start has been returned by
+// Token and whose end has not.
+type tokenFrame struct {
+	dict  bool
+	value bool   // a key has been read, and its value is next
+	depth int    // the Decoder's depth within the container
+	n     int    // number of entries or elements begun
+	key   []byte // key of the current entry, if known
+}
+
+// beginEntry records that the next entry of a dictionary has begun, with
+// the given key if it is known.
+func (f *tokenFrame) beginEntry(key []byte) {
+	f.value = true
+	f.n++
+	f.key = append(f.key[:0], key...)
+}
+
+// Token returns the next token in the input, so that documents of any size
+// can be processed in constant memory. At the end of the input, Token
+// returns nil, io.EOF.
+//
+// Token checks that the delimiters it returns are properly nested and that
+// dictionary keys are byte strings, but not that keys are sorted. A whole
+// value may be read in place of its tokens by Decode, Skip, DecodeDictFunc
+// or DecodeListFunc, which consume a key or a value in a dictionary as
+// Token would.
+func (dec *Decoder) Token() (Token, error) {
+	d := &dec.d
+	f := dec.tokenFrame()
+	if f == nil {
+		if err := dec.begin(); err != nil {
+			return nil, err
+		}
+	} else {
+		c, err := d.peek()
+		if err != nil {
+			return nil, err
+		}
+		if c == 'e' && !f.value {
+			d.off++
+			dec.tokens = dec.tokens[:len(dec.tokens)-1]
+			dec.depth--
+			if f.dict {
+				return DictEnd, nil
+			}
+			return ListEnd, nil
+		}
+		if f.dict && !f.value {
+			key, err := d.readKeyBytes()
+			if err != nil {
+				return nil, err
+			}
+			f.beginEntry(key)
+			return d.bytes(key), nil
+		}
+		f.value = false
+		if !f.dict {
+			f.n++
+		}
+	}
+
+	c, err := d.peekValue()
+	if err != nil {
+		return nil, err
+	}
+	switch c {
+	case 'i':
+		if d.overflow == IntOverflowBigInt {
+			return d.readIntOrBigInt()
+		}
+		return d.readInt64()
+
+	case 'd', 'l':
+		d.depth = dec.depth
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		d.off++
+		dec.depth++
+		dec.pushToken(c == 'd')
+		if c == 'd' {
+			return DictStart, nil
+		}
+		return ListStart, nil
+
+	default:
+		b, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return d.bytes(b), nil
+	}
+}
+
+// pushToken records the start of a dictionary or list returned by Token,
+// reusing the key buffer of a frame ended before.
+func (dec *Decoder) pushToken(dict bool) {
+	n := len(dec.tokens)
+	if n == cap(dec.tokens) {
+		dec.tokens = append(dec.tokens, tokenFrame{})
+	}
+	dec.tokens = dec.tokens[:n+1]
+	dec.tokens[n] = tokenFrame{dict: dict, depth: dec.depth, key: dec.tokens[n].key[:0]}
+}
+
+// Depth returns the number of dictionaries and lists begun by Token and
+// not yet ended, within which the next token lies.
+func (dec *Decoder) Depth() int {
+	return len(dec.tokens)
+}
+
+// Path returns the path within the value being read by Token of the last
+// token returned, in the form taken by ExtractPath: the keys and list
+// indices leading to it, separated by dots. A dictionary key is taken as
+// naming its value, so that at the key "files" of the "info" dictionary, as
+// at the start of the "files" list and at its end, Path returns
+// "info.files", and at the first element of that list "info.files.0",
+// allowing filters to pick out parts of a document as it streams past. The
+// key of an entry is unknown, and so left empty, if it was read by Decode
+// or Skip rather than by Token.
+func (dec *Decoder) Path() string {
+	var path []byte
+	for i := range dec.tokens {
+		f := &dec.tokens[i]
+		if f.n == 0 {
+			break
+		}
+		if i > 0 {
+			path = append(path, '.')
+		}
+		if f.dict {
+			path = append(path, f.key...)
+		} else {
+			path = strconv.AppendInt(path, int64(f.n-1), 10)
+		}
+	}
+	return string(path)
+}
+
+// tokenFrame returns the innermost container begun by Token, if the next
+// value is one of its elements rather than within a container begun since
+// by DecodeDictFunc or DecodeListFunc.
+func (dec *Decoder) tokenFrame() *tokenFrame {
+	if len(dec.tokens) == 0 {
+		return nil
+	}
+	f := &dec.tokens[len(dec.tokens)-1]
+	if f.depth != dec.depth {
+		return nil
+	}
+	return f
+}
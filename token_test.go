@@ -0,0 +1,227 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var tokenTests = []struct {
+	input    string
+	expected []Token
+	err      error
+}{
+	{"i42e", []Token{int64(42)}, io.EOF},
+	{"4:spam", []Token{[]byte("spam")}, io.EOF},
+	{"le", []Token{ListStart, ListEnd}, io.EOF},
+	{"d1:ali1ei2ee1:bdee", []Token{
+		DictStart, []byte("a"), ListStart, int64(1), int64(2), ListEnd,
+		[]byte("b"), DictStart, DictEnd, DictEnd,
+	}, io.EOF},
+	{"i1e0:", []Token{int64(1), []byte(nil)}, io.EOF},
+	{"l1:a", []Token{ListStart, []byte("a")}, io.ErrUnexpectedEOF},
+	{"di1ei2ee", []Token{DictStart}, &SyntaxError{"dict key is not a string", 1}},
+	{"d1:ae", []Token{DictStart, []byte("a")}, &SyntaxError{"invalid character 'e' looking for beginning of value", 4}},
+	{"li9223372036854775808ee", []Token{ListStart}, &UnmarshalTypeError{
+		Value: "integer 9223372036854775808", Type: int64Type, Offset: 1,
+	}},
+	{"e", nil, &SyntaxError{"invalid character 'e' looking for beginning of value", 0}},
+}
+
+func TestDecoderToken(t *testing.T) {
+	for _, test := range tokenTests {
+		dec := NewDecoder(iotest.OneByteReader(strings.NewReader(test.input)))
+		var got []Token
+		var err error
+		for {
+			var tok Token
+			if tok, err = dec.Token(); err != nil {
+				break
+			}
+			got = append(got, tok)
+		}
+		if !reflect.DeepEqual(got, test.expected) || !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%q:\ngot:      %#v, %#v\nexpected: %#v, %#v", test.input, got, err, test.expected, test.err)
+		}
+	}
+}
+
+func TestDecoderTokenMixed(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d5:peersld2:ip1:xee8:intervali1800e4:skip1:xe"))
+	var peers []Dict
+	var interval int
+	var keys []string
+	tok, err := dec.Token()
+	if err != nil || tok != DictStart {
+		t.Fatalf("got %#v, %v; expected DictStart", tok, err)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := string(tok.([]byte))
+		keys = append(keys, key)
+		switch key {
+		case "peers":
+			err = dec.Decode(&peers)
+		case "interval":
+			err = dec.Decode(&interval)
+		default:
+			err = dec.Skip()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tok, err := dec.Token(); err != nil || tok != DictEnd {
+		t.Fatalf("got %#v, %v; expected DictEnd", tok, err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		t.Errorf("got %v, expected io.EOF", err)
+	}
+
+	if expected := []string{"peers", "interval", "skip"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", keys, expected)
+	}
+	if expected := []Dict{{"ip": "x"}}; !reflect.DeepEqual(peers, expected) || interval != 1800 {
+		t.Errorf("\ngot:      %#v, %d\nexpected: %#v, 1800", peers, interval, expected)
+	}
+}
+
+func TestDecoderTokenBigInt(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("i18446744073709551616e"), WithIntOverflow(IntOverflowBigInt))
+	tok, err := dec.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := new(big.Int).SetString("18446744073709551616", 10)
+	if n, ok := tok.(*big.Int); !ok || n.Cmp(expected) != 0 {
+		t.Errorf("\ngot:      %#v\nexpected: %v", tok, expected)
+	}
+}
+
+var peekKindTests = []struct {
+	input    string
+	expected Kind
+	err      error
+}{
+	{"i1e", IntKind, nil},
+	{"3:abc", StringKind, nil},
+	{"le", ListKind, nil},
+	{"de", DictKind, nil},
+	{"", InvalidKind, io.EOF},
+	{"x", InvalidKind, &SyntaxError{"invalid character 'x' looking for beginning of value", 0}},
+}
+
+func TestDecoderPeekKind(t *testing.T) {
+	for _, test := range peekKindTests {
+		dec := NewDecoder(strings.NewReader(test.input))
+		kind, err := dec.PeekKind()
+		if kind != test.expected || !reflect.DeepEqual(err, test.err) {
+			t.Errorf("%q:\ngot:      %v, %#v\nexpected: %v, %#v", test.input, kind, err, test.expected, test.err)
+		}
+		if err != nil {
+			continue
+		}
+		// The value is still there to be decoded.
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			t.Errorf("%q: %v", test.input, err)
+		}
+	}
+}
+
+func TestDecoderPeekKindToken(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("d1:ai1e1:bl1:xee"))
+	var kinds []Kind
+	for {
+		kind, err := dec.PeekKind()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			kinds = append(kinds, kind)
+		}
+		if _, err := dec.Token(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []Kind{DictKind, StringKind, IntKind, StringKind, ListKind, StringKind}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("\ngot:      %v\nexpected: %v", kinds, expected)
+	}
+}
+
+func TestDecoderPath(t *testing.T) {
+	input := "d8:announce1:u4:infod5:filesld6:lengthi1e4:pathl1:aeed6:lengthi2eee4:name1:xee"
+	dec := NewDecoder(strings.NewReader(input))
+	var got []string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, ok := tok.([]byte); ok {
+			tok = string(b)
+		}
+		got = append(got, fmt.Sprintf("%v %s %d", tok, dec.Path(), dec.Depth()))
+	}
+	expected := []string{
+		"d  1",
+		"announce announce 1",
+		"u announce 1",
+		"info info 1",
+		"d info 2",
+		"files info.files 2",
+		"l info.files 3",
+		"d info.files.0 4",
+		"length info.files.0.length 4",
+		"1 info.files.0.length 4",
+		"path info.files.0.path 4",
+		"l info.files.0.path 5",
+		"a info.files.0.path.0 5",
+		"e info.files.0.path 4",
+		"e info.files.0 3",
+		"d info.files.1 4",
+		"length info.files.1.length 4",
+		"2 info.files.1.length 4",
+		"e info.files.1 3",
+		"e info.files 2",
+		"name info.name 2",
+		"x info.name 2",
+		"e info 1",
+		"e  0",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", got, expected)
+	}
+}
+
+func TestDecoderPathDecode(t *testing.T) {
+	// Keys and values read by Decode or Skip are counted in the path.
+	dec := NewDecoder(strings.NewReader("d1:ali1ei2ee1:bli3eee"))
+	var paths []string
+	dec.Token()
+	dec.Skip()
+	dec.Skip()
+	paths = append(paths, dec.Path())
+	dec.Token()
+	dec.Token()
+	dec.Skip()
+	paths = append(paths, dec.Path())
+	if expected := []string{"", "b.0"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("\ngot:      %#v\nexpected: %#v", paths, expected)
+	}
+}
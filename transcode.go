@@ -0,0 +1,67 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+// A KeyFilter decides what becomes of a dictionary entry passed through
+// Transcode, given its key. It returns the key to write the entry under,
+// which may be key itself, and false if the entry is to be dropped.
+type KeyFilter func(key []byte) (newKey []byte, keep bool)
+
+// Transcode reads the next bencoded value from dec and writes it to enc a
+// token at a time, so that values of any size can be passed through in
+// constant memory, as when scrubbing the traffic of a tracker proxy. If
+// filter is not nil, it is called with the key of each dictionary entry to
+// drop the entry, whose value is then skipped unread, or to rename it.
+// Renamed keys must keep the sorted order of the entries, unless enc is set
+// not to sort keys. To act only on keys at certain places in the value,
+// filter can consult dec.Path, which includes the key.
+//
+// Like Decode, Transcode returns io.EOF if the input is exhausted before
+// the value begins.
+func Transcode(enc *Encoder, dec *Decoder, filter KeyFilter) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch tok := tok.(type) {
+		case Delim:
+			switch tok {
+			case DictStart:
+				err = enc.BeginDict()
+				depth++
+			case ListStart:
+				err = enc.BeginList()
+				depth++
+			default:
+				err = enc.End()
+				depth--
+			}
+
+		case []byte:
+			if f := dec.tokenFrame(); f != nil && f.dict && f.value {
+				if filter != nil {
+					var keep bool
+					if tok, keep = filter(tok); !keep {
+						err = dec.Skip()
+						break
+					}
+				}
+				err = enc.Key(string(tok))
+			} else {
+				err = enc.Encode(tok)
+			}
+
+		default:
+			err = enc.Encode(tok)
+		}
+
+		if err != nil || depth == 0 {
+			return err
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 The Chihaya Authors. All rights reserved.
+// Use of this source code is governed by the BSD 2-Clause license,
+// which can be found in the LICENSE file.
+
+package bencode
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// scrubFilter drops "peer id" and "key" entries and renames "ip" to "host".
+func scrubFilter(key []byte) ([]byte, bool) {
+	switch string(key) {
+	case "peer id", "key":
+		return nil, false
+	case "ip":
+		return []byte("host"), true
+	}
+	return key, true
+}
+
+var transcodeTests = []struct {
+	input    string
+	filter   KeyFilter
+	expected string
+	err      error
+}{
+	{"i42e", nil, "i42e", nil},
+	{"4:spam", scrubFilter, "4:spam", nil},
+	{"d1:ali1e1:xe1:bdee", nil, "d1:ali1e1:xe1:bdee", nil},
+	{"d2:ip8:10.0.0.13:keyd1:xi1ee7:peer id2:ab4:porti6881ee", scrubFilter, "d4:host8:10.0.0.14:porti6881ee", nil},
+	{"l3:keyd3:key3:key2:ip0:ee", scrubFilter, "l3:keyd4:host0:ee", nil},
+	{"", nil, "", io.EOF},
+	{"l1:a", nil, "l1:a", io.ErrUnexpectedEOF},
+}
+
+func TestTranscode(t *testing.T) {
+	for _, test := range transcodeTests {
+		var buf bytes.Buffer
+		dec := NewDecoder(iotest.OneByteReader(strings.NewReader(test.input)))
+		err := Transcode(NewEncoder(&buf), dec, test.filter)
+		if buf.String() != test.expected || err != test.err {
+			t.Errorf("%q:\ngot:      %q, %v\nexpected: %q, %v", test.input, buf.String(), err, test.expected, test.err)
+		}
+	}
+
+	// Renaming "ip" to "host" puts it out of order after "id".
+	var buf bytes.Buffer
+	err := Transcode(NewEncoder(&buf), NewDecoder(strings.NewReader("d2:id0:2:ip0:e")), scrubFilter)
+	if expected := "d2:id0:"; err == nil || buf.String() != expected {
+		t.Errorf("\ngot:      %q, %v\nexpected: %q and an error", buf.String(), err, expected)
+	}
+}
+
+func TestTranscodeStream(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	dec := NewDecoder(strings.NewReader("d3:key1:xei1e"))
+	for {
+		err := Transcode(enc, dec, scrubFilter)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := "dei1e"; buf.String() != expected {
+		t.Errorf("\ngot:      %q\nexpected: %q", buf.String(), expected)
+	}
+}
+
+func TestTranscodePath(t *testing.T) {
+	// Only the "ip" of each peer is dropped.
+	dec := NewDecoder(strings.NewReader("d2:ip8:10.0.0.15:peersld2:ip8:10.0.0.24:porti1eeee"))
+	filter := func(key []byte) ([]byte, bool) {
+		return key, !strings.HasPrefix(dec.Path(), "peers.") || string(key) != "ip"
+	}
+	var buf bytes.Buffer
+	if err := Transcode(NewEncoder(&buf), dec, filter); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "d2:ip8:10.0.0.15:peersld4:porti1eeee"; buf.String() != expected {
+		t.Errorf("\ngot:      %q\nexpected: %q", buf.String(), expected)
+	}
+}